@@ -1,14 +1,35 @@
 package client
 
+import (
+	"github.com/SnDragon/lrpc-go/metadata"
+	"time"
+)
+
 type Call struct {
 	Seq           uint64
 	ServiceMethod string
 	Args          interface{}
 	Reply         interface{}
+	Metadata      metadata.MD   // sent with the request
+	Trailer       metadata.MD   // set by the server with the response
+	Timeout       time.Duration // time left before the caller's deadline, sent with the request
 	Error         error
 	Done          chan *Call
+
+	// set by Client.send for the metrics of the call
+	metrics  *clientMetrics
+	endpoint string
+	start    time.Time
 }
 
 func (c *Call) done() {
+	c.observe()
 	c.Done <- c
 }
+
+// observe records the outcome of a call sent by Client.send.
+func (c *Call) observe() {
+	if c.metrics != nil {
+		c.metrics.observe(c.endpoint, c.ServiceMethod, c.Error, time.Since(c.start))
+	}
+}
@@ -2,13 +2,14 @@ package client
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/metadata"
 	"github.com/SnDragon/lrpc-go/server"
+	"github.com/SnDragon/lrpc-go/status"
 	"io"
 	"net"
 	"net/http"
@@ -18,20 +19,29 @@ import (
 )
 
 type Client struct {
-	cc       codec.Codec
-	opt      *server.Option
-	sending  sync.Mutex
-	header   codec.Header
-	mu       sync.Mutex
-	pending  map[uint64]*Call
-	seq      uint64
-	closing  bool // 客户端主动关闭
-	shutdown bool // 出现错误被动关闭
+	cc          codec.Codec
+	opt         *server.Option
+	addr        string // protocol@addr of the server
+	interceptor UnaryClientInterceptor
+	log         logger.Logger
+	metrics     *clientMetrics
+	sending     sync.Mutex
+	header      codec.Header
+	mu          sync.Mutex
+	pending     map[uint64]*Call
+	seq         uint64
+	closing     bool // 客户端主动关闭
+	shutdown    bool // 出现错误被动关闭
+	goingAway   bool // 服务端发送了 GOAWAY, 不再发起新调用
 }
 
 var _ io.Closer = (*Client)(nil)
 
-var ErrShutDown = errors.New("connection is shutdown")
+var ErrShutDown error = status.New(status.Unavailable, "connection is shutdown")
+
+// ErrGoAway is returned for calls made after the server sent GOAWAY,
+// they have not been sent and can be retried on another connection.
+var ErrGoAway = fmt.Errorf("%w: server is going away", ErrShutDown)
 
 func (c *Client) Close() error {
 	c.mu.Lock()
@@ -46,7 +56,22 @@ func (c *Client) Close() error {
 func (c *Client) IsAvailable() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return !c.closing && !c.shutdown
+	return !c.closing && !c.shutdown && !c.goingAway
+}
+
+// GoingAway reports whether the server sent GOAWAY. The calls in flight still
+// complete, but new calls fail with ErrGoAway.
+func (c *Client) GoingAway() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.goingAway
+}
+
+// Pending returns the number of calls waiting for their response.
+func (c *Client) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
 }
 
 func (c *Client) registerCall(call *Call) (uint64, error) {
@@ -55,6 +80,9 @@ func (c *Client) registerCall(call *Call) (uint64, error) {
 	if c.closing || c.shutdown {
 		return 0, ErrShutDown
 	}
+	if c.goingAway {
+		return 0, ErrGoAway
+	}
 	call.Seq = c.seq
 	c.pending[c.seq] = call
 	c.seq++
@@ -82,6 +110,7 @@ func (c *Client) terminalCalls(err error) {
 }
 
 func (c *Client) send(call *Call) {
+	call.metrics, call.endpoint, call.start = c.metrics, c.addr, time.Now()
 	c.sending.Lock()
 	defer c.sending.Unlock()
 	seq, err := c.registerCall(call)
@@ -93,6 +122,8 @@ func (c *Client) send(call *Call) {
 	c.header.Seq = seq
 	c.header.ServiceMethod = call.ServiceMethod
 	c.header.Error = ""
+	c.header.Metadata = call.Metadata
+	c.header.Timeout = call.Timeout
 	if err := c.cc.Write(&c.header, call.Args); err != nil {
 		call := c.removeCall(seq)
 		if call != nil {
@@ -102,6 +133,22 @@ func (c *Client) send(call *Call) {
 	}
 }
 
+// cancelBody is the placeholder body of cancel frames
+var cancelBody = struct{}{}
+
+// cancel tells the server to stop handling the call of seq, if the server supports it.
+func (c *Client) cancel(seq uint64) {
+	if c.opt.Features&server.FeatureCancel == 0 {
+		return
+	}
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	h := &codec.Header{Type: codec.FrameTypeCancel, Seq: seq}
+	if err := c.cc.Write(h, cancelBody); err != nil {
+		c.log.Warn("rpc client: send cancel", logger.Uint64("seq", seq), logger.Err(err))
+	}
+}
+
 func (c *Client) Go(serviceName string, argv, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
@@ -114,21 +161,73 @@ func (c *Client) Go(serviceName string, argv, reply interface{}, done chan *Call
 		Reply:         reply,
 		Done:          done,
 	}
+	if c.interceptor != nil {
+		go func() {
+			ctx := WithTrailer(context.Background(), &call.Trailer)
+			call.Error = c.interceptor(ctx, c.callInfo(serviceName), argv, reply, c.call)
+			call.done()
+		}()
+		return call
+	}
 	c.send(call)
 	return call
 }
+
+// Call invokes the named function and waits for it to complete.
+// Metadata attached to ctx by metadata.NewOutgoingContext is sent with the request,
+// the trailer of the response is stored to the MD registered with WithTrailer.
+// The deadline of ctx is sent as well, the server gives up at the same time.
 func (c *Client) Call(ctx context.Context, serviceName string, argv, reply interface{}) error {
-	done := make(chan *Call, 1)
-	call := c.Go(serviceName, argv, reply, done)
+	if c.interceptor != nil {
+		return c.interceptor(ctx, c.callInfo(serviceName), argv, reply, c.call)
+	}
+	return c.call(ctx, serviceName, argv, reply)
+}
+
+func (c *Client) callInfo(serviceMethod string) *CallInfo {
+	return &CallInfo{ServiceMethod: serviceMethod, Addr: c.addr}
+}
+
+// call is the UnaryInvoker at the end of the interceptor chain.
+func (c *Client) call(ctx context.Context, serviceName string, argv, reply interface{}) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	call := &Call{
+		ServiceMethod: serviceName,
+		Args:          argv,
+		Reply:         reply,
+		Metadata:      md,
+		Done:          make(chan *Call, 1),
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.Timeout = time.Until(deadline); call.Timeout <= 0 {
+			return status.Errorf(status.DeadlineExceeded, "rpc client: call failed: %v", context.DeadlineExceeded)
+		}
+	}
+	c.send(call)
 	select {
 	case <-ctx.Done():
-		c.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		err := status.Convert(fmt.Errorf("rpc client: call failed: %w", ctx.Err()))
+		if c.removeCall(call.Seq) != nil {
+			c.cancel(call.Seq)
+			call.Error = err
+			call.observe()
+		}
+		return err
 	case call := <-call.Done:
+		if t, ok := ctx.Value(trailerKey{}).(*metadata.MD); ok {
+			*t = call.Trailer
+		}
 		return call.Error
 	}
 }
 
+type trailerKey struct{}
+
+// WithTrailer returns a context which makes Client.Call store the trailer of the response to md.
+func WithTrailer(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, trailerKey{}, md)
+}
+
 func (c *Client) receive() {
 	var err error
 	for err == nil {
@@ -136,18 +235,28 @@ func (c *Client) receive() {
 		if err = c.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		if h.Type == codec.FrameTypeGoAway {
+			c.mu.Lock()
+			c.goingAway = true
+			c.mu.Unlock()
+			err = c.cc.ReadBody(nil)
+			continue
+		}
 		call := c.removeCall(h.Seq)
+		if call != nil {
+			call.Trailer = h.Metadata
+		}
 		switch {
 		case call == nil:
 			err = c.cc.ReadBody(nil)
 		case h.Error != "":
 			err = c.cc.ReadBody(nil)
-			call.Error = errors.New(h.Error)
+			call.Error = serverError(c.log, &h)
 			call.done()
 		default:
 			err = c.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("error reading body:" + err.Error())
+				call.Error = status.Errorf(status.Internal, "error reading body:%v", err)
 			}
 			call.done()
 		}
@@ -155,18 +264,87 @@ func (c *Client) receive() {
 	c.terminalCalls(err)
 }
 
+// serverError turns the error of a response back into the error the server returned,
+// so callers can check it with errors.Is, and with errors.As for a *status.Status.
+func serverError(log logger.Logger, h *codec.Header) error {
+	if h.Code == 0 {
+		// 老版本服务端不发送错误码
+		return legacyServerError(h.Error)
+	}
+	details, err := status.DecodeDetails(h.Details)
+	if err != nil {
+		log.Warn("rpc client: decode status details", logger.Uint64("seq", h.Seq),
+			logger.String("method", h.ServiceMethod), logger.Err(err))
+	}
+	st := &status.Status{Code: status.Code(h.Code), Message: h.Error, Details: details}
+	// 只还原服务端自身的错误, 方法返回的错误原样交给调用方
+	info := serverErrorInfo(details)
+	if info == nil {
+		return st
+	}
+	switch info.Reason {
+	case server.ReasonServerClosed:
+		// 服务端在 GOAWAY 之后收到的请求, 并未执行
+		return ErrGoAway
+	case server.ReasonOverloaded:
+		return server.ErrOverloaded
+	case server.ReasonRateLimited:
+		retryAfter, _ := status.RetryAfter(st)
+		return &server.RateLimitError{ServiceMethod: h.ServiceMethod, Key: info.Metadata["key"], RetryAfter: retryAfter}
+	case server.ReasonInternal:
+		return internalError(h.Error)
+	}
+	return st
+}
+
+// serverErrorInfo returns the status.ErrorInfo marking an error of the server itself, see server.ErrorDomain.
+func serverErrorInfo(details []status.Detail) *status.ErrorInfo {
+	for _, d := range details {
+		if info, ok := d.(*status.ErrorInfo); ok && info.Domain == server.ErrorDomain {
+			return info
+		}
+	}
+	return nil
+}
+
+// legacyServerError recognizes the errors of a server that doesn't send status codes by their message.
+func legacyServerError(msg string) error {
+	switch msg {
+	case server.ErrServerClosed.Error():
+		return ErrGoAway
+	case server.ErrOverloaded.Error():
+		return server.ErrOverloaded
+	}
+	if e, ok := server.ParseRateLimitError(msg); ok {
+		return e
+	}
+	if strings.HasPrefix(msg, server.ErrInternal.Error()) {
+		return internalError(msg)
+	}
+	return errors.New(msg)
+}
+
+// internalError wraps server.ErrInternal, keeping the rest of msg, e.g. the panicking method.
+func internalError(msg string) error {
+	rest := strings.TrimPrefix(msg, server.ErrInternal.Error())
+	if rest == "" {
+		return server.ErrInternal
+	}
+	if rest == msg {
+		rest = ": " + msg
+	}
+	return fmt.Errorf("%w%s", server.ErrInternal, rest)
+}
+
 type clientResult struct {
 	client *Client
 	err    error
 }
 
-type newClientFunc func(conn net.Conn, opt *server.Option) (*Client, error)
+type newClientFunc func(conn net.Conn, opt *Options) (*Client, error)
 
-func DialTimeout(f newClientFunc, network, address string, opts ...server.OptionFunc) (client *Client, err error) {
-	opt := server.DefaultOption
-	for _, optFunc := range opts {
-		optFunc(&opt)
-	}
+func DialTimeout(f newClientFunc, network, address string, opts ...DialOption) (client *Client, err error) {
+	opt := newOptions(opts)
 	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
 	if err != nil {
 		return nil, err
@@ -178,62 +356,89 @@ func DialTimeout(f newClientFunc, network, address string, opts ...server.Option
 	}()
 	ch := make(chan *clientResult)
 	go func() {
-		c, err := f(conn, &opt)
+		c, err := f(conn, opt)
 		ch <- &clientResult{
 			client: c,
 			err:    err,
 		}
 	}()
-	if opt.ConnectTimeout == 0 {
-		ret := <-ch
-		return ret.client, ret.err
+	var timeout <-chan time.Time
+	if opt.ConnectTimeout > 0 {
+		timeout = time.After(opt.ConnectTimeout)
 	}
 	select {
-	case <-time.After(opt.ConnectTimeout):
+	case <-timeout:
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
 	case ret := <-ch:
+		if ret.client != nil {
+			ret.client.addr = network + "@" + address
+		}
 		return ret.client, ret.err
 	}
 }
 
-func Dial(network, address string, opts ...server.OptionFunc) (client *Client, err error) {
+func Dial(network, address string, opts ...DialOption) (client *Client, err error) {
 	return DialTimeout(NewClient, network, address, opts...)
 }
 
-func NewClient(conn net.Conn, opt *server.Option) (*Client, error) {
+func NewClient(conn net.Conn, opt *Options) (*Client, error) {
+	log := optionLogger(opt).With(logger.String("peer", conn.RemoteAddr().String()))
 	f := codec.CodecTypeMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codecType:%v", opt.CodecType)
-		fmt.Println("NewClient err:", err)
+		log.Error("rpc client: new client", logger.Err(err))
 		return nil, err
 	}
-	// send option
-	// json.NewEncoder(conn).Encode(opt)
-	//conn.Write()
-
-	// 魔术方法
-	buf := bytes.NewBuffer([]byte{})
-	binary.Write(buf, binary.BigEndian, opt.MagicNumber)
-	conn.Write(buf.Bytes())
-	// codecType
-	buf = bytes.NewBuffer([]byte{})
-	binary.Write(buf, binary.BigEndian, uint32(opt.CodecType))
-	conn.Write(buf.Bytes())
-	return newClientCodec(f(conn), opt), nil
+	if codec.GetSerializer(opt.SerializationType) == nil {
+		return nil, fmt.Errorf("invalid serializationType:%v", opt.SerializationType)
+	}
+	if codec.GetCompressor(opt.CompressType) == nil {
+		return nil, fmt.Errorf("invalid compressType:%v", opt.CompressType)
+	}
+	if err := server.WriteHandshake(conn, &opt.Option); err != nil {
+		return nil, fmt.Errorf("rpc client: write handshake: %w", err)
+	}
+	reply, err := server.ReadHandshakeReply(conn)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: %w", err)
+	}
+	opt.Version, opt.Features = reply.Version, reply.Features
+	cc := f(conn)
+	if fc, ok := cc.(codec.FormatCodec); ok {
+		fc.SetFormat(opt.SerializationType, opt.CompressType)
+	}
+	if lc, ok := cc.(codec.LoggingCodec); ok {
+		lc.SetLogger(log)
+	}
+	c := newClientCodec(cc, opt, log)
+	// Dial 会换成 protocol@addr 的形式
+	c.addr = conn.RemoteAddr().Network() + "@" + conn.RemoteAddr().String()
+	return c, nil
+}
+
+// optionLogger returns the Logger set by WithLogger, logger.Default() if none.
+func optionLogger(opt *Options) logger.Logger {
+	if opt.Logger != nil {
+		return opt.Logger
+	}
+	return logger.Default()
 }
 
-func newClientCodec(codec codec.Codec, opt *server.Option) *Client {
+func newClientCodec(codec codec.Codec, opt *Options, log logger.Logger) *Client {
 	c := &Client{
-		seq:     1,
-		cc:      codec,
-		opt:     opt,
-		pending: map[uint64]*Call{},
+		seq:         1,
+		cc:          codec,
+		opt:         &opt.Option,
+		interceptor: chainInterceptors(opt.Interceptors),
+		log:         log,
+		metrics:     newClientMetrics(opt),
+		pending:     map[uint64]*Call{},
 	}
 	go c.receive()
 	return c
 }
 
-func NewHTTPClient(conn net.Conn, opt *server.Option) (*Client, error) {
+func NewHTTPClient(conn net.Conn, opt *Options) (*Client, error) {
 	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", server.DefaultRPCPath))
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err == nil && resp.Status == server.Connected {
@@ -245,7 +450,7 @@ func NewHTTPClient(conn net.Conn, opt *server.Option) (*Client, error) {
 	return nil, err
 }
 
-func DialHTTP(network, address string, opts ...server.OptionFunc) (client *Client, err error) {
+func DialHTTP(network, address string, opts ...DialOption) (client *Client, err error) {
 	return DialTimeout(NewHTTPClient, network, address, opts...)
 }
 
@@ -253,16 +458,23 @@ func DialHTTP(network, address string, opts ...server.OptionFunc) (client *Clien
 // according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
 // eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
-func XDial(rpcAddr string, opts ...server.OptionFunc) (*Client, error) {
+func XDial(rpcAddr string, opts ...DialOption) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@addr", rpcAddr)
 	}
 	protocol, addr := parts[0], parts[1]
+	var c *Client
+	var err error
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts...)
+		c, err = DialHTTP("tcp", addr, opts...)
 	default:
-		return Dial(protocol, addr, opts...)
+		c, err = Dial(protocol, addr, opts...)
+	}
+	if err != nil {
+		return nil, err
 	}
+	c.addr = rpcAddr
+	return c, nil
 }
@@ -2,10 +2,19 @@ package client
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/metadata"
+	"github.com/SnDragon/lrpc-go/metrics"
 	"github.com/SnDragon/lrpc-go/server"
+	"github.com/SnDragon/lrpc-go/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -20,7 +29,7 @@ func TestClient_dialTimeout(t *testing.T) {
 	t.Parallel()
 	l, _ := net.Listen("tcp", ":0")
 
-	f := func(conn net.Conn, opt *server.Option) (client *Client, err error) {
+	f := func(conn net.Conn, opt *Options) (client *Client, err error) {
 		_ = conn.Close()
 		time.Sleep(time.Second * 2)
 		return nil, nil
@@ -50,6 +59,52 @@ func (b Bar) Timeout(argv int, reply *int) error {
 	return nil
 }
 
+func (b Bar) Square(argv int, reply *int) error {
+	*reply = argv * argv
+	return nil
+}
+
+func (b Bar) Echo(args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = args.Value
+	return nil
+}
+
+// Metadata replies with the incoming metadata of key and sends it back in the trailer.
+func (b Bar) Metadata(ctx context.Context, key string, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Get(key)
+	return server.SetTrailer(ctx, metadata.Pairs(key, *reply))
+}
+
+// Peer replies with the client address seen by the server, and whether a deadline is set.
+func (b Bar) Peer(ctx context.Context, argv int) (*string, error) {
+	p, ok := server.PeerFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no peer")
+	}
+	_, hasDeadline := ctx.Deadline()
+	reply := fmt.Sprintf("%s %v", p.Addr, hasDeadline)
+	return &reply, nil
+}
+
+var blocked = make(chan error, 1)
+
+// Block waits until the call is cancelled and reports why.
+func (b Bar) Block(ctx context.Context, argv int, reply *int) error {
+	<-ctx.Done()
+	blocked <- ctx.Err()
+	return ctx.Err()
+}
+
+// Fail returns a status error with code argv and an ErrorInfo.
+func (b Bar) Fail(argv int, reply *int) error {
+	return status.New(status.Code(argv), "failed").WithDetails(&status.ErrorInfo{Reason: "OUT_OF_STOCK"})
+}
+
+func (b Bar) Panic(argv int, reply *int) error {
+	panic("boom")
+}
+
 func startServer(addr chan string) {
 	var b Bar
 	s := server.NewServer()
@@ -68,7 +123,8 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		fmt.Println("err:", err)
@@ -81,3 +137,804 @@ func TestClient_Call(t *testing.T) {
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
 }
+
+func TestClient_codecs(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	formats := map[string][]DialOption{
+		"json":            {server.WithCodecType(codec.CodecTypeJson)},
+		"frame":           {server.WithCodecType(codec.CodecTypeFrame)},
+		"frame json+gzip": {server.WithCodecType(codec.CodecTypeFrame), server.WithSerializationType(codec.SerializationTypeJson), server.WithCompressType(codec.CompressTypeGzip)},
+	}
+	for name, opts := range formats {
+		t.Run(name, func(t *testing.T) {
+			client, err := Dial("tcp", addr, opts...)
+			_assert(err == nil, "dial err: %v", err)
+			defer func() { _ = client.Close() }()
+			var reply int
+			err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+			_assert(err == nil && reply == 9, "expect 9, got %d, err: %v", reply, err)
+		})
+	}
+	t.Run("pb", func(t *testing.T) {
+		client, err := Dial("tcp", addr, server.WithCodecType(codec.CodecTypePB))
+		_assert(err == nil, "dial err: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "proto.Message"), "expect a proto.Message error, got %v", err)
+		echo := &wrapperspb.StringValue{}
+		err = client.Call(context.Background(), "Bar.Echo", wrapperspb.String("hello"), echo)
+		_assert(err == nil && echo.Value == "hello", "expect hello, got %q, err: %v", echo.Value, err)
+	})
+	t.Run("invalid serialization", func(t *testing.T) {
+		_, err := Dial("tcp", addr, server.WithCodecType(codec.CodecTypeFrame), server.WithSerializationType(100))
+		_assert(err != nil && strings.Contains(err.Error(), "serializationType"), "expect an invalid serializationType error")
+	})
+}
+
+func TestClient_handshake(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	t.Run("rejected", func(t *testing.T) {
+		conn, _ := net.Dial("tcp", addr)
+		opt := server.DefaultOption
+		opt.CodecType = 100
+		_ = server.WriteHandshake(conn, &opt)
+		reply, err := server.ReadHandshakeReply(conn)
+		_assert(errors.Is(err, server.ErrHandshakeRejected), "expect a rejected handshake, got %v", err)
+		_assert(strings.Contains(reply.Reason, "codecType"), "expect the reason, got %q", reply.Reason)
+	})
+	t.Run("legacy", func(t *testing.T) {
+		conn, _ := net.Dial("tcp", addr)
+		_ = binary.Write(conn, binary.BigEndian, [2]uint32{server.MagicNumber, uint32(codec.CodecTypeGob)})
+		client := newClientCodec(codec.NewCodecTypeGob(conn), newOptions(nil), logger.Nop())
+		defer func() { _ = client.Close() }()
+		var reply int
+		err := client.Call(context.Background(), "Bar.Square", 3, &reply)
+		_assert(err == nil && reply == 9, "expect 9, got %d, err: %v", reply, err)
+	})
+}
+
+func TestClient_metadata(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	for _, codecType := range []codec.CodecType{codec.CodecTypeGob, codec.CodecTypeJson, codec.CodecTypeFrame} {
+		t.Run(fmt.Sprint(codecType), func(t *testing.T) {
+			client, err := Dial("tcp", addr, server.WithCodecType(codecType))
+			_assert(err == nil, "dial err: %v", err)
+			defer func() { _ = client.Close() }()
+			var trailer metadata.MD
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "request-id", "42")
+			ctx = WithTrailer(ctx, &trailer)
+			var reply string
+			err = client.Call(ctx, "Bar.Metadata", "request-id", &reply)
+			_assert(err == nil && reply == "42", "expect 42, got %q, err: %v", reply, err)
+			_assert(trailer.Get("request-id") == "42", "expect trailer 42, got %v", trailer)
+		})
+	}
+}
+
+func TestClient_peer(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	conn, _ := net.Dial("tcp", addr)
+	client, err := NewClient(conn, &Options{Option: server.Option{
+		MagicNumber:       server.MagicNumber,
+		Version:           server.ProtocolVersion,
+		CodecType:         codec.CodecTypeGob,
+		SerializationType: codec.SerializationTypeGob,
+		HandleTimeout:     time.Second,
+	}})
+	_assert(err == nil, "NewClient err: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Bar.Peer", 0, &reply)
+	_assert(err == nil && reply == conn.LocalAddr().String()+" true", "expect peer address with a deadline, got %q, err: %v", reply, err)
+}
+
+func TestClient_deadline(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Bar.Peer", 0, &reply)
+	_assert(err == nil && strings.HasSuffix(reply, "false"), "expect no deadline, got %q, err: %v", reply, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Call(ctx, "Bar.Peer", 0, &reply)
+	_assert(err == nil && strings.HasSuffix(reply, "true"), "expect the deadline of ctx, got %q, err: %v", reply, err)
+	t.Run("server timeout", func(t *testing.T) {
+		// the server gives up together with the caller
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		call := &Call{ServiceMethod: "Bar.Timeout", Args: 1, Reply: &reply, Timeout: time.Millisecond * 100, Done: make(chan *Call, 1)}
+		client.send(call)
+		select {
+		case <-ctx.Done():
+			t.Fatal("expect the server to time out first")
+		case call := <-call.Done:
+			_assert(call.Error != nil && strings.Contains(call.Error.Error(), "handle timeout"), "expect a timeout error, got %v", call.Error)
+		}
+	})
+}
+
+func TestClient_cancel(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+	var reply int
+	err = client.Call(ctx, "Bar.Block", 0, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "canceled"), "expect a canceled error, got %v", err)
+	select {
+	case err := <-blocked:
+		_assert(err == context.Canceled, "expect the handler to be cancelled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect the handler to be cancelled")
+	}
+	// the connection keeps working
+	err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+	_assert(err == nil && reply == 9, "expect 9, got %d, err: %v", reply, err)
+}
+
+func TestClient_panic(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Bar.Panic", 3, &reply)
+	_assert(errors.Is(err, server.ErrInternal) && strings.Contains(err.Error(), "Bar.Panic"), "expect an internal error, got %v", err)
+	err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+	_assert(err == nil && reply == 9, "expect the server to keep serving, got %d, err: %v", reply, err)
+}
+
+func TestClient_interceptors(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	var addrs []string
+	tagging := func(ctx context.Context, info *CallInfo, args, reply interface{}, invoker UnaryInvoker) error {
+		addrs = append(addrs, info.Addr)
+		ctx = metadata.AppendToOutgoingContext(ctx, "request-id", "7")
+		return invoker(ctx, info.ServiceMethod, args, reply)
+	}
+	cached := func(ctx context.Context, info *CallInfo, args, reply interface{}, invoker UnaryInvoker) error {
+		if info.ServiceMethod == "Bar.Square" {
+			*reply.(*int) = 100
+			return nil
+		}
+		return invoker(ctx, info.ServiceMethod, args, reply)
+	}
+	client, err := XDial("tcp@"+addr, WithInterceptors(tagging, cached))
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Bar.Metadata", "request-id", &reply)
+	_assert(err == nil && reply == "7", "expect metadata added by the interceptor, got %q, err: %v", reply, err)
+	call := <-client.Go("Bar.Metadata", "request-id", &reply, nil).Done
+	_assert(call.Error == nil && reply == "7" && call.Trailer.Get("request-id") == "7", "expect Go to run the interceptors, got %q, err: %v", reply, call.Error)
+	var square int
+	err = client.Call(context.Background(), "Bar.Square", 3, &square)
+	_assert(err == nil && square == 100, "expect the cached reply, got %d, err: %v", square, err)
+	_assert(len(addrs) == 3 && addrs[0] == "tcp@"+addr, "expect the server address, got %v", addrs)
+}
+
+func TestClient_shutdown(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	s := server.NewServer()
+	_ = s.Register(&b)
+	l, _ := net.Listen("tcp", ":0")
+	accepted := make(chan error, 1)
+	go func() { accepted <- s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	call := client.Go("Bar.Timeout", 3, &reply, make(chan *Call, 1))
+	time.Sleep(time.Millisecond * 100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	_assert(<-accepted == server.ErrServerClosed, "expect Accept to return ErrServerClosed")
+	time.Sleep(time.Millisecond * 100)
+	_assert(!client.IsAvailable() && client.GoingAway(), "expect the client to receive GOAWAY")
+	var square int
+	err = client.Call(context.Background(), "Bar.Square", 3, &square)
+	_assert(errors.Is(err, ErrGoAway) && errors.Is(err, ErrShutDown), "expect ErrGoAway, got %v", err)
+	<-call.Done
+	_assert(call.Error == nil && reply == 9, "expect the call in flight to complete, got %d, err: %v", reply, call.Error)
+	_assert(<-shutdown == nil, "expect Shutdown to drain the connection")
+	_, err = Dial("tcp", l.Addr().String())
+	_assert(err != nil, "expect the listener to be closed")
+}
+
+// Waiter blocks until its call is cancelled.
+type Waiter struct{}
+
+func (w Waiter) Wait(ctx context.Context, argv int, reply *int) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestClient_admission(t *testing.T) {
+	t.Parallel()
+	start := func(opts ...server.ServerOption) (*server.Server, string) {
+		var b Bar
+		s := server.NewServer(opts...)
+		_ = s.Register(&b)
+		_ = s.Register(new(Waiter))
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go func() { _ = s.Accept(l) }()
+		return s, l.Addr().String()
+	}
+	t.Run("max conns", func(t *testing.T) {
+		s, addr := start(server.WithMaxConns(1))
+		defer func() { _ = s.Close() }()
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial err: %v", err)
+		_, err = Dial("tcp", addr)
+		_assert(errors.Is(err, server.ErrHandshakeRejected) && strings.Contains(err.Error(), "too many connections"),
+			"expect the handshake to be rejected, got %v", err)
+		_assert(s.NumTooManyConns() == 1, "expect 1 rejected connection, got %d", s.NumTooManyConns())
+		_ = client.Close()
+		time.Sleep(time.Millisecond * 100)
+		client, err = Dial("tcp", addr)
+		_assert(err == nil, "expect a free slot after close, err: %v", err)
+		_ = client.Close()
+	})
+	t.Run("max inflight", func(t *testing.T) {
+		s, addr := start(server.WithMaxInflight(1))
+		defer func() { _ = s.Close() }()
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial err: %v", err)
+		defer func() { _ = client.Close() }()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+			defer cancel()
+			var reply int
+			_ = client.Call(ctx, "Bar.Timeout", 1, &reply)
+		}()
+		time.Sleep(time.Millisecond * 50)
+		begin := time.Now()
+		var reply int
+		err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+		_assert(err == nil && reply == 9, "expect 9, got %d, err: %v", reply, err)
+		_assert(time.Since(begin) >= time.Millisecond*200, "expect the call to wait for a free slot, took %s", time.Since(begin))
+	})
+	t.Run("cancel at the limit", func(t *testing.T) {
+		s, addr := start(server.WithMaxInflight(1))
+		defer func() { _ = s.Close() }()
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial err: %v", err)
+		defer func() { _ = client.Close() }()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*100, cancel)
+		var reply int
+		err = client.Call(ctx, "Waiter.Wait", 0, &reply)
+		_assert(status.CodeOf(err) == status.Canceled, "expect Canceled, got %v", err)
+		// the cancel frame is read at the limit and frees the slot
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err = client.Call(ctx, "Bar.Square", 3, &reply)
+		_assert(err == nil && reply == 9, "expect 9, got %d, err: %v", reply, err)
+	})
+	t.Run("calls waiting at the limit", func(t *testing.T) {
+		s, addr := start(server.WithMaxInflight(1))
+		defer func() { _ = s.Close() }()
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial err: %v", err)
+		ctx, cancel := context.WithCancel(context.Background())
+		waited := make(chan struct{})
+		go func() {
+			_ = client.Call(ctx, "Waiter.Wait", 0, new(int))
+			close(waited)
+		}()
+		time.Sleep(time.Millisecond * 50)
+		var reply int
+		waiting := client.Go("Bar.Square", 3, &reply, nil)
+		overloaded := client.Go("Bar.Square", 3, new(int), nil)
+		<-overloaded.Done
+		_assert(errors.Is(overloaded.Error, server.ErrOverloaded), "expect ErrOverloaded beyond the waiting calls, got %v", overloaded.Error)
+		// the cancel frame is read while a call waits for the slot
+		cancel()
+		<-waited
+		<-waiting.Done
+		_assert(waiting.Error == nil && reply == 9, "expect 9, got %d, err: %v", reply, waiting.Error)
+
+		// the connection is closed while a call waits for the slot
+		client.Go("Waiter.Wait", 0, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+		client.Go("Bar.Square", 3, new(int), nil)
+		time.Sleep(time.Millisecond * 50)
+		_ = client.Close()
+		for i := 0; len(s.Connections()) != 0; i++ {
+			_assert(i < 100, "expect the connection to be gone, got %+v", s.Connections())
+			time.Sleep(time.Millisecond * 10)
+		}
+	})
+	t.Run("deny", func(t *testing.T) {
+		s, addr := start(server.WithDenyCIDRs("127.0.0.0/8"))
+		defer func() { _ = s.Close() }()
+		_, err := Dial("tcp", addr)
+		_assert(err != nil, "expect the connection to be refused")
+		_assert(s.NumDenied() == 1, "expect 1 denied connection, got %d", s.NumDenied())
+	})
+}
+
+func TestClient_overloaded(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	s := server.NewServer(server.WithWorkerPool(1, 0))
+	_ = s.Register(&b)
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	busy := make(chan struct{})
+	go func() {
+		defer close(busy)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+		defer cancel()
+		var reply int
+		_ = client.Call(ctx, "Bar.Timeout", 1, &reply)
+	}()
+	time.Sleep(time.Millisecond * 100)
+	var reply int
+	err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+	_assert(errors.Is(err, server.ErrOverloaded), "expect ErrOverloaded, got %v", err)
+	<-busy
+	// the worker is busy until Bar.Timeout returns, even though its caller gave up
+	err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+	_assert(errors.Is(err, server.ErrOverloaded), "expect ErrOverloaded while the method runs, got %v", err)
+	for i := 0; ; i++ {
+		time.Sleep(time.Millisecond * 100)
+		if err = client.Call(context.Background(), "Bar.Square", 3, &reply); err == nil || i == 30 {
+			break
+		}
+	}
+	_assert(err == nil && reply == 9, "expect 9 once the worker is free, got %d, err: %v", reply, err)
+}
+
+func TestClient_rateLimit(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	s := server.NewServer()
+	_ = s.Register(&b, server.WithMethodRateLimit("Square", 1, 2), server.WithKeyedRateLimit("client-id", 1, 1))
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 2; i++ {
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", fmt.Sprint(i)))
+		err = client.Call(ctx, "Bar.Square", 3, &reply)
+		_assert(err == nil, "expect call %d to be within the burst, err: %v", i, err)
+	}
+	err = client.Call(metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", "2")), "Bar.Square", 3, &reply)
+	var rle *server.RateLimitError
+	_assert(errors.As(err, &rle) && rle.ServiceMethod == "Bar.Square" && rle.RetryAfter > 0, "expect a method rate limit error, got %v", err)
+
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("client-id", "0"))
+	var echo string
+	err = client.Call(ctx, "Bar.Metadata", "client-id", &echo)
+	_assert(errors.Is(err, server.ErrRateLimited) && errors.As(err, &rle) && rle.Key == "0", "expect client 0 to be limited, got %v", err)
+}
+
+func TestClient_status(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	for _, codecType := range []codec.CodecType{codec.CodecTypeGob, codec.CodecTypeJson, codec.CodecTypeFrame} {
+		client, err := Dial("tcp", addr, server.WithCodecType(codecType))
+		_assert(err == nil, "dial err: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Bar.Fail", int(status.FirstAppCode+1), &reply)
+		var st *status.Status
+		_assert(errors.As(err, &st) && st.Code == status.FirstAppCode+1 && st.Message == "failed",
+			"codec %d: expect the status of the method, got %v", codecType, err)
+		_assert(len(st.Details) == 1 && st.Details[0].(*status.ErrorInfo).Reason == "OUT_OF_STOCK",
+			"codec %d: expect the ErrorInfo, got %+v", codecType, st.Details)
+		_ = client.Close()
+	}
+
+	client, _ := Dial("tcp", addr, server.WithHandleTimeout(time.Millisecond*100))
+	var reply int
+	err := client.Call(context.Background(), "Bar.Timeout", 1, &reply)
+	_assert(status.CodeOf(err) == status.DeadlineExceeded && strings.Contains(err.Error(), "handle timeout"), "expect DeadlineExceeded, got %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = client.Call(ctx, "Bar.Timeout", 1, &reply)
+	_assert(status.CodeOf(err) == status.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	_ = client.Close()
+	err = client.Call(context.Background(), "Bar.Square", 1, &reply)
+	_assert(errors.Is(err, ErrShutDown) && status.CodeOf(err) == status.Unavailable, "expect Unavailable, got %v", err)
+}
+
+func TestServerError(t *testing.T) {
+	header := func(st *status.Status, msg string) *codec.Header {
+		details, _ := status.EncodeDetails(st.Details)
+		return &codec.Header{ServiceMethod: "Bar.Square", Code: uint32(st.Code), Error: msg, Details: details}
+	}
+	// 服务端自身的错误按 ErrorInfo 识别, 与错误信息无关
+	err := serverError(logger.Nop(), header(server.ErrServerClosed.(*status.Status), "closing"))
+	_assert(errors.Is(err, ErrGoAway), "expect ErrGoAway, got %v", err)
+	err = serverError(logger.Nop(), header(server.ErrOverloaded.(*status.Status), "busy"))
+	_assert(errors.Is(err, server.ErrOverloaded), "expect ErrOverloaded, got %v", err)
+	limited := &server.RateLimitError{ServiceMethod: "Bar.Square", Key: "a", RetryAfter: time.Second}
+	err = serverError(logger.Nop(), header(limited.Status(), "slow down"))
+	var rle *server.RateLimitError
+	_assert(errors.As(err, &rle) && *rle == *limited, "expect a rate limit error, got %v", err)
+	err = serverError(logger.Nop(), header(server.ErrInternal.(*status.Status), "oops"))
+	_assert(errors.Is(err, server.ErrInternal) && strings.Contains(err.Error(), "oops"), "expect ErrInternal, got %v", err)
+
+	// 方法返回的错误即使错误码相同也原样返回
+	for _, code := range []status.Code{status.Unavailable, status.ResourceExhausted, status.Internal} {
+		failed := status.New(code, "db down").WithDetails(&status.ErrorInfo{Reason: "DB_DOWN"})
+		err = serverError(logger.Nop(), header(failed, "db down"))
+		var st *status.Status
+		_assert(!errors.Is(err, ErrShutDown) && !errors.Is(err, server.ErrOverloaded) && !errors.Is(err, server.ErrInternal),
+			"%s: expect the error of the method, got %v", code, err)
+		_assert(errors.As(err, &st) && st.Code == code && st.Message == "db down" && len(st.Details) == 1,
+			"%s: expect the status of the method, got %+v", code, st)
+	}
+
+	// 老版本服务端只能按错误信息识别
+	err = serverError(logger.Nop(), &codec.Header{Error: server.ErrOverloaded.Error()})
+	_assert(errors.Is(err, server.ErrOverloaded), "expect ErrOverloaded, got %v", err)
+	err = serverError(logger.Nop(), &codec.Header{Error: "oops"})
+	_assert(!errors.Is(err, server.ErrInternal) && err.Error() == "oops", "expect a plain error, got %v", err)
+}
+
+func TestClient_unknownService(t *testing.T) {
+	t.Parallel()
+	var b Bar
+	s := server.NewServer()
+	_ = s.Register(&b)
+	var got []string
+	s.SetUnknownServiceHandler(func(ctx context.Context, serviceMethod string, body []byte) ([]byte, error) {
+		got = append(got, serviceMethod)
+		if serviceMethod == "Proxy.Fail" {
+			return nil, status.Errorf(status.Unavailable, "no backend")
+		}
+		return body, nil
+	})
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	addr := l.Addr().String()
+
+	for _, codecType := range []codec.CodecType{codec.CodecTypeJson, codec.CodecTypeFrame} {
+		client, err := Dial("tcp", addr, server.WithCodecType(codecType), server.WithSerializationType(codec.SerializationTypeJson))
+		_assert(err == nil, "dial err: %v", err)
+		var reply string
+		err = client.Call(context.Background(), "Proxy.Echo", "hello", &reply)
+		_assert(err == nil && reply == "hello", "codec %d: expect the raw body echoed, got %q, err: %v", codecType, reply, err)
+		err = client.Call(context.Background(), "Bar.Missing", "hi", &reply)
+		_assert(err == nil && reply == "hi", "codec %d: expect unknown methods handled too, got %q, err: %v", codecType, reply, err)
+		err = client.Call(context.Background(), "Proxy.Fail", "hello", &reply)
+		_assert(status.CodeOf(err) == status.Unavailable, "codec %d: expect the handler error, got %v", codecType, err)
+		_ = client.Close()
+	}
+	_assert(len(got) == 6 && got[1] == "Bar.Missing", "expect the raw service methods, got %v", got)
+
+	// gob can't pass raw bodies through
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Proxy.Echo", 1, &reply)
+	_assert(status.CodeOf(err) == status.NotFound, "expect NotFound with gob, got %v", err)
+}
+
+func TestClient_notFound(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	for _, codecType := range []codec.CodecType{codec.CodecTypeGob, codec.CodecTypeJson, codec.CodecTypeFrame} {
+		client, err := Dial("tcp", addr, server.WithCodecType(codecType))
+		_assert(err == nil, "dial err: %v", err)
+		var reply int
+		for _, serviceMethod := range []string{"Bar.Missing", "Missing.Square", "Square"} {
+			err = client.Call(context.Background(), serviceMethod, 3, &reply)
+			_assert(status.CodeOf(err) == status.NotFound, "codec %d: expect NotFound for %s, got %v", codecType, serviceMethod, err)
+		}
+		err = client.Call(context.Background(), "Bar.Square", 3, &reply)
+		_assert(err == nil && reply == 9, "codec %d: expect the connection to keep serving, got %d, err: %v", codecType, reply, err)
+		_ = client.Close()
+	}
+}
+
+// Gate replies with its name once release is closed.
+type Gate struct {
+	name    string
+	release chan struct{}
+}
+
+func (g *Gate) Name(argv int, reply *string) error {
+	if g.release != nil {
+		<-g.release
+	}
+	*reply = g.name
+	return nil
+}
+
+func TestClient_replace(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	release := make(chan struct{})
+	_ = s.Register(&Gate{name: "v1", release: release})
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var old string
+	call := client.Go("Gate.Name", 0, &old, nil)
+	time.Sleep(time.Millisecond * 100)
+	_assert(s.Replace("Gate", &Gate{name: "v2"}) == nil, "expect Replace to succeed")
+	var reply string
+	err = client.Call(context.Background(), "Gate.Name", 0, &reply)
+	_assert(err == nil && reply == "v2", "expect new calls on the new receiver, got %q, err: %v", reply, err)
+	close(release)
+	<-call.Done
+	_assert(call.Error == nil && old == "v1", "expect the call in flight to finish on the old receiver, got %q, err: %v", old, call.Error)
+
+	_assert(s.Unregister("Gate") == nil, "expect Unregister to succeed")
+	err = client.Call(context.Background(), "Gate.Name", 0, &reply)
+	_assert(status.CodeOf(err) == status.NotFound, "expect NotFound after Unregister, got %v", err)
+}
+
+func TestClient_version(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	_ = s.Register(&Gate{name: "v1"}, server.WithVersion("v1"))
+	_ = s.Register(&Gate{name: "v2"}, server.WithVersion("v2"))
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	for version, want := range map[string]string{"": "v1", "v1": "v1", "v2": "v2"} {
+		ctx := context.Background()
+		if version != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, server.VersionKey, version)
+		}
+		var reply string
+		err = client.Call(ctx, "Gate.Name", 0, &reply)
+		_assert(err == nil && reply == want, "version %q: expect %q, got %q, err: %v", version, want, reply, err)
+	}
+	var reply string
+	ctx := metadata.AppendToOutgoingContext(context.Background(), server.VersionKey, "v3")
+	err = client.Call(ctx, "Gate.Name", 0, &reply)
+	_assert(status.CodeOf(err) == status.NotFound, "expect NotFound for an unknown version, got %v", err)
+}
+
+// syncBuffer is written by the loggers of the server goroutines while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestClient_logger(t *testing.T) {
+	t.Parallel()
+	var serverLog, clientLog syncBuffer
+	s := server.NewServer(server.WithLogger(logger.New(&serverLog, logger.LevelDebug)))
+	_ = s.Register(new(Bar))
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String(), WithLogger(logger.New(&clientLog, logger.LevelDebug)))
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_ = client.Call(context.Background(), "Bar.Panic", 3, &reply)
+	got := serverLog.String()
+	_assert(strings.Contains(got, "method registered service=Bar method=Square"), "expect the registration in the log, got %q", got)
+	line := ""
+	for _, l := range strings.Split(got, "\n") {
+		if strings.Contains(l, "rpc server: panic") {
+			line = l
+		}
+	}
+	for _, field := range []string{" ERROR ", " peer=", " seq=1 ", " method=Bar.Panic "} {
+		_assert(strings.Contains(line, field), "expect %q in the panic entry, got %q", field, line)
+	}
+}
+
+func TestClient_metrics(t *testing.T) {
+	t.Parallel()
+	serverMetrics, clientMetrics := metrics.NewRegistry(), metrics.NewRegistry()
+	s := server.NewServer(server.WithMetrics(serverMetrics))
+	_ = s.Register(new(Bar))
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String(), WithMetrics(clientMetrics))
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+	// a handshake with a wrong magic number
+	conn, _ := net.Dial("tcp", l.Addr().String())
+	_ = server.WriteHandshake(conn, &server.Option{MagicNumber: 1})
+	_, _ = server.ReadHandshakeReply(conn)
+	_ = conn.Close()
+
+	var reply int
+	_ = client.Call(context.Background(), "Bar.Square", 3, &reply)
+	_ = client.Call(context.Background(), "Bar.Square", 4, &reply)
+	_ = client.Call(context.Background(), "Bar.Fail", int(status.InvalidArgument), &reply)
+	_ = client.Call(context.Background(), "Bar.Nope", 0, &reply)
+
+	var b strings.Builder
+	_ = serverMetrics.WritePrometheus(&b)
+	got := b.String()
+	for _, line := range []string{
+		`lrpc_server_requests_total{method="Bar.Square",code="OK"} 2`,
+		`lrpc_server_requests_total{method="Bar.Fail",code="InvalidArgument"} 1`,
+		`lrpc_server_requests_total{method="unknown",code="NotFound"} 1`,
+		`lrpc_server_requests_in_flight{method="Bar.Square"} 0`,
+		`lrpc_server_request_duration_seconds_count{method="Bar.Square"} 2`,
+		`lrpc_server_request_bytes_count{method="Bar.Square"} 2`,
+		`lrpc_server_response_bytes_count{method="Bar.Square"} 2`,
+		`lrpc_server_connections 1`,
+		`lrpc_server_handshake_failures_total{reason="option"} 1`,
+	} {
+		_assert(strings.Contains(got, line+"\n"), "expect %q in the server metrics:\n%s", line, got)
+	}
+
+	b.Reset()
+	_ = clientMetrics.WritePrometheus(&b)
+	got = b.String()
+	endpoint := "tcp@" + l.Addr().String()
+	for _, line := range []string{
+		`lrpc_client_requests_total{endpoint="` + endpoint + `",method="Bar.Square",code="OK"} 2`,
+		`lrpc_client_requests_total{endpoint="` + endpoint + `",method="Bar.Fail",code="InvalidArgument"} 1`,
+		`lrpc_client_request_duration_seconds_count{endpoint="` + endpoint + `",method="Bar.Square"} 2`,
+	} {
+		_assert(strings.Contains(got, line+"\n"), "expect %q in the client metrics:\n%s", line, got)
+	}
+}
+
+func TestClient_connections(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	release := make(chan struct{})
+	_ = s.Register(&Gate{name: "stuck", release: release})
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String(), server.WithCodecType(codec.CodecTypeJson))
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	call := client.Go("Gate.Name", 0, &reply, nil)
+	var conns []server.ConnInfo
+	for i := 0; i < 100; i++ {
+		if conns = s.Connections(); len(conns) == 1 && len(conns[0].Calls) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_assert(len(conns) == 1 && len(conns[0].Calls) == 1, "expect one connection with a call in flight, got %+v", conns)
+	conn := conns[0]
+	_assert(conn.Option.CodecType == codec.CodecTypeJson && conn.BytesIn > 0 && conn.BytesOut == 0,
+		"unexpected connection %+v", conn)
+	_assert(conn.Calls[0].Method == "Gate.Name" && conn.Calls[0].Seq == 1 && conn.Calls[0].Age > 0,
+		"unexpected call %+v", conn.Calls[0])
+
+	_assert(s.CloseConnection(conn.ID) == nil, "expect CloseConnection to succeed")
+	select {
+	case <-call.Done:
+		_assert(call.Error != nil, "expect the call to fail once its connection is closed")
+	case <-time.After(time.Second):
+		t.Fatal("expect the call to fail once its connection is closed")
+	}
+	// the connection is served until its method returns
+	_assert(len(s.Connections()) == 1, "expect the connection to wait for its method")
+	close(release)
+	for i := 0; s.CloseConnection(conn.ID) != server.ErrConnNotFound; i++ {
+		_assert(i < 100, "expect the connection to be gone")
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestClient_connectionsTimedOut(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	release := make(chan struct{})
+	defer close(release)
+	_ = s.Register(&Gate{name: "stuck", release: release})
+	defer func() { _ = s.Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String(), server.WithHandleTimeout(time.Millisecond*50))
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "Gate.Name", 0, &reply)
+	_assert(status.CodeOf(err) == status.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	// 超时已经应答, 但方法仍在运行
+	conns := s.Connections()
+	_assert(len(conns) == 1 && len(conns[0].Calls) == 1 && conns[0].Calls[0].Method == "Gate.Name",
+		"expect the stuck call to be listed, got %+v", conns)
+}
+
+func TestClient_shutdownTimedOut(t *testing.T) {
+	t.Parallel()
+	s := server.NewServer()
+	release := make(chan struct{})
+	_ = s.Register(&Gate{name: "stuck", release: release})
+	l, _ := net.Listen("tcp", ":0")
+	go func() { _ = s.Accept(l) }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial err: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	var reply string
+	err = client.Call(ctx, "Gate.Name", 0, &reply)
+	_assert(status.CodeOf(err) == status.DeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	// the call has been answered but its method is still running
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	_assert(s.Shutdown(ctx) == context.DeadlineExceeded, "expect Shutdown to wait for the method")
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_assert(s.Shutdown(ctx) == nil, "expect Shutdown to drain once the method returns")
+}
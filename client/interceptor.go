@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+)
+
+// CallInfo describes the call a client interceptor wraps.
+type CallInfo struct {
+	ServiceMethod string // `service.method`
+	Addr          string // the server the call is sent to, as protocol@addr
+}
+
+// UnaryInvoker sends the call, or runs the next client interceptor of the chain.
+type UnaryInvoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// UnaryClientInterceptor wraps every call of a client. It may add metadata to ctx,
+// call invoker any number of times, or fill reply itself without calling it.
+type UnaryClientInterceptor func(ctx context.Context, info *CallInfo, args, reply interface{}, invoker UnaryInvoker) error
+
+// WithInterceptors appends interceptors to the chain of the client,
+// the first one is the outermost.
+func WithInterceptors(interceptors ...UnaryClientInterceptor) DialOption {
+	return optionFunc(func(o *Options) {
+		// 复制一份, 避免与其他 Options 共用底层数组
+		o.Interceptors = append(append([]UnaryClientInterceptor(nil), o.Interceptors...), interceptors...)
+	})
+}
+
+// chainInterceptors folds interceptors into one, nil if there is none.
+func chainInterceptors(interceptors []UnaryClientInterceptor) UnaryClientInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, info *CallInfo, args, reply interface{}, invoker UnaryInvoker) error {
+		var next func(i int) UnaryInvoker
+		next = func(i int) UnaryInvoker {
+			if i == len(interceptors) {
+				return invoker
+			}
+			return func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+				return interceptors[i](ctx, info, args, reply, next(i+1))
+			}
+		}
+		return next(0)(ctx, info.ServiceMethod, args, reply)
+	}
+}
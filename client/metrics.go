@@ -0,0 +1,32 @@
+package client
+
+import (
+	"time"
+
+	"github.com/SnDragon/lrpc-go/metrics"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// clientMetrics are shared by the clients recording in the same registry.
+type clientMetrics struct {
+	requests *metrics.CounterVec   // endpoint, method, code
+	duration *metrics.HistogramVec // endpoint, method
+}
+
+func newClientMetrics(opt *Options) *clientMetrics {
+	r := opt.Metrics
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	return &clientMetrics{
+		requests: r.Counter("lrpc_client_requests_total",
+			"Calls made by clients, by server endpoint, method and status code.", "endpoint", "method", "code"),
+		duration: r.Histogram("lrpc_client_request_duration_seconds",
+			"Time from sending a call to getting its response.", metrics.DefaultBuckets, "endpoint", "method"),
+	}
+}
+
+func (m *clientMetrics) observe(endpoint, method string, err error, d time.Duration) {
+	m.requests.With(endpoint, method, status.CodeOf(err).String()).Inc()
+	m.duration.With(endpoint, method).Observe(d.Seconds())
+}
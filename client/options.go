@@ -0,0 +1,54 @@
+package client
+
+import (
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/metrics"
+	"github.com/SnDragon/lrpc-go/server"
+)
+
+// DialOption configures a client, see Dial. A server.OptionFunc, e.g. server.WithCodecType,
+// sets the server.Option sent in the handshake, the options of this package, e.g.
+// WithInterceptors, set the Options only the client uses.
+type DialOption interface {
+	ApplyOption(opt *server.Option)
+}
+
+// Options configures a client, only the Option is sent to the server.
+type Options struct {
+	server.Option
+	Interceptors []UnaryClientInterceptor // see WithInterceptors
+	Logger       logger.Logger            // nil for logger.Default()
+	Metrics      *metrics.Registry        // nil for metrics.DefaultRegistry
+}
+
+// WithMetrics records the metrics of the client in r instead of metrics.DefaultRegistry.
+func WithMetrics(r *metrics.Registry) DialOption {
+	return optionFunc(func(o *Options) {
+		o.Metrics = r
+	})
+}
+
+// WithLogger sets the Logger of the client and of its codec.
+func WithLogger(l logger.Logger) DialOption {
+	return optionFunc(func(o *Options) {
+		o.Logger = l
+	})
+}
+
+// optionFunc is a DialOption of this package, it leaves the server.Option alone.
+type optionFunc func(o *Options)
+
+func (optionFunc) ApplyOption(*server.Option) {}
+
+// newOptions returns the Options of server.DefaultOption with opts applied.
+func newOptions(opts []DialOption) *Options {
+	o := &Options{Option: server.DefaultOption}
+	for _, opt := range opts {
+		if f, ok := opt.(optionFunc); ok {
+			f(o)
+			continue
+		}
+		opt.ApplyOption(&o.Option)
+	}
+	return o
+}
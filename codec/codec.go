@@ -1,11 +1,38 @@
 package codec
 
-import "io"
+import (
+	"io"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+// FrameType tells calls apart from control frames, which carry no body.
+type FrameType uint8
+
+const (
+	FrameTypeCall   FrameType = 0 // request or response
+	FrameTypeCancel FrameType = 1 // sent by the client when the call of Seq is abandoned
+	FrameTypeGoAway FrameType = 2 // sent by the server before it shuts down, no new calls should follow
+)
 
 type Header struct {
-	ServiceMethod string // `service.method`
-	Seq           uint64
-	Error         string
+	Type          FrameType `json:"type,omitempty"`
+	ServiceMethod string    `json:"service_method"` // `service.method`
+	Seq           uint64    `json:"seq"`
+	Error         string    `json:"error,omitempty"`
+	// Code and Details are set with Error, see package status. Code 0 comes from servers that don't send codes.
+	Code    uint32 `json:"code,omitempty"`
+	Details string `json:"details,omitempty"`
+	// Timeout is the time left before the caller's deadline when the request was sent, 0 for none.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Metadata is set by the caller on requests, and carries the trailer on responses.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// hasBody reports whether a body is encoded after h.
+func (h *Header) hasBody() bool {
+	return h.Error == "" && h.Type == FrameTypeCall
 }
 
 type Codec interface {
@@ -15,11 +42,38 @@ type Codec interface {
 	Write(h *Header, body interface{}) error
 }
 
+// FormatCodec is implemented by codecs whose body is encoded through the
+// Serializer and Compressor registries.
+type FormatCodec interface {
+	Codec
+	SetFormat(serializationType, compressType int)
+}
+
+// LoggingCodec is implemented by codecs that log, e.g. the errors of Write.
+// They use logger.Default() unless SetLogger is called before the codec is used.
+type LoggingCodec interface {
+	Codec
+	SetLogger(l logger.Logger)
+}
+
+// RawBody is a body that is already serialized, a RawCodec writes it as is.
+type RawBody []byte
+
+// RawCodec is implemented by codecs that can pass bodies through undecoded,
+// e.g. for a server forwarding calls it doesn't know. Gob can't, its stream carries type state.
+type RawCodec interface {
+	Codec
+	// ReadRawBody returns the body kept by ReadHeader, decompressed but not deserialized.
+	ReadRawBody() ([]byte, error)
+}
+
 type CodecType int
 
 const (
-	CodecTypeGob  CodecType = 1
-	CodecTypeJson CodecType = 2 // unimplemented
+	CodecTypeGob   CodecType = 1
+	CodecTypeJson  CodecType = 2
+	CodecTypeFrame CodecType = 3
+	CodecTypePB    CodecType = 4 // args and replies must be proto.Message
 )
 
 var CodecTypeMap = make(map[CodecType]NewCodecType)
@@ -28,4 +82,7 @@ type NewCodecType func(closer io.ReadWriteCloser) Codec
 
 func init() {
 	CodecTypeMap[CodecTypeGob] = NewCodecTypeGob
+	CodecTypeMap[CodecTypeJson] = NewCodecTypeJson
+	CodecTypeMap[CodecTypeFrame] = NewCodecTypeFrame
+	CodecTypeMap[CodecTypePB] = NewCodecTypePB
 }
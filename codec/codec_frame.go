@@ -0,0 +1,265 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+// MaxFrameSize 单帧最大长度,超过则认为连接数据异常
+const MaxFrameSize = 64 << 20
+
+// FrameCodec is a length-prefixed binary codec. Every message is one frame:
+//
+//	| frame len uint32 | type uint8 | seq uint64 | timeout int64 | method len uint16 | method | error len uint32 | error |
+//	| code uint32 | details len uint32 | details | metadata count uint16 | { key len uint16 | key | value len uint32 | value }... |
+//	| serialization uint8 | compress uint8 | body len uint32 | body |
+//
+// All integers are big-endian, frame len counts the bytes after itself and timeout is in nanoseconds.
+// The body is produced by Marshal and then Compress with the types carried in the frame.
+type FrameCodec struct {
+	conn io.ReadWriteCloser
+	*wireSize
+	buf *bufio.Writer
+	log logger.Logger
+
+	mu                sync.Mutex
+	serializationType int
+	compressType      int
+
+	// body of the frame read by the last ReadHeader
+	body              []byte
+	bodySerialization int
+	bodyCompress      int
+}
+
+func NewCodecTypeFrame(conn io.ReadWriteCloser) Codec {
+	ws := newWireSize(conn)
+	return &FrameCodec{
+		conn:              conn,
+		wireSize:          ws,
+		buf:               bufio.NewWriter(ws.w),
+		log:               logger.Default(),
+		serializationType: SerializationTypeGob,
+		compressType:      CompressTypeNoop,
+	}
+}
+
+// SetFormat sets the serialization and compression used by Write.
+func (c *FrameCodec) SetFormat(serializationType, compressType int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serializationType = serializationType
+	c.compressType = compressType
+}
+
+func (c *FrameCodec) SetLogger(l logger.Logger) {
+	c.log = l
+}
+
+func (c *FrameCodec) format() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serializationType, c.compressType
+}
+
+func (c *FrameCodec) Close() error {
+	return c.conn.Close()
+}
+
+// ReadHeader reads a whole frame and keeps its body for the following ReadBody.
+// The format of the frame is adopted for later writes, so a server answers in
+// whatever format its client speaks.
+func (c *FrameCodec) ReadHeader(h *Header) error {
+	c.startRead()
+	var size uint32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size > MaxFrameSize {
+		return fmt.Errorf("rpc codec: frame too large: %d", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		return err
+	}
+	fr := frameReader{data: frame}
+	h.Type = FrameType(fr.uint8())
+	h.Seq = fr.uint64()
+	h.Timeout = time.Duration(fr.uint64())
+	h.ServiceMethod = string(fr.bytes(int(fr.uint16())))
+	h.Error = string(fr.bytes(int(fr.uint32())))
+	h.Code = fr.uint32()
+	h.Details = string(fr.bytes(int(fr.uint32())))
+	h.Metadata = nil
+	if n := int(fr.uint16()); n > 0 {
+		h.Metadata = make(map[string]string, n)
+		for i := 0; i < n && fr.err == nil; i++ {
+			k := string(fr.bytes(int(fr.uint16())))
+			h.Metadata[k] = string(fr.bytes(int(fr.uint32())))
+		}
+	}
+	c.bodySerialization = int(fr.uint8())
+	c.bodyCompress = int(fr.uint8())
+	c.body = fr.bytes(int(fr.uint32()))
+	if fr.err != nil {
+		return fr.err
+	}
+	c.SetFormat(c.bodySerialization, c.bodyCompress)
+	return nil
+}
+
+func (c *FrameCodec) ReadBody(body interface{}) error {
+	data := c.body
+	c.body = nil
+	if body == nil {
+		return nil
+	}
+	data, err := Decompress(c.bodyCompress, data)
+	if err != nil {
+		return err
+	}
+	return Unmarshal(c.bodySerialization, data, body)
+}
+
+func (c *FrameCodec) ReadRawBody() ([]byte, error) {
+	data := c.body
+	c.body = nil
+	return Decompress(c.bodyCompress, data)
+}
+
+// Write encodes h and body as one frame. Error responses and control frames carry no body.
+// A RawBody is taken as already serialized in the format of the codec.
+func (c *FrameCodec) Write(h *Header, body interface{}) (err error) {
+	c.startWrite()
+	serializationType, compressType := c.format()
+	var data []byte
+	if h.hasBody() {
+		if raw, ok := body.(RawBody); ok {
+			data = raw
+		} else if data, err = Marshal(serializationType, body); err != nil {
+			c.log.Error("rpc codec: frame error encoding body", logger.Err(err))
+			return err
+		}
+		if data, err = Compress(compressType, data); err != nil {
+			c.log.Error("rpc codec: frame error compressing body", logger.Err(err))
+			return err
+		}
+	}
+	if len(h.ServiceMethod) > 0xffff || len(h.Metadata) > 0xffff {
+		return errors.New("rpc codec: service method or metadata too long")
+	}
+	var frame bytes.Buffer
+	var n [8]byte
+	frame.WriteByte(uint8(h.Type))
+	binary.BigEndian.PutUint64(n[:], h.Seq)
+	frame.Write(n[:8])
+	binary.BigEndian.PutUint64(n[:], uint64(h.Timeout))
+	frame.Write(n[:8])
+	binary.BigEndian.PutUint16(n[:], uint16(len(h.ServiceMethod)))
+	frame.Write(n[:2])
+	frame.WriteString(h.ServiceMethod)
+	binary.BigEndian.PutUint32(n[:], uint32(len(h.Error)))
+	frame.Write(n[:4])
+	frame.WriteString(h.Error)
+	binary.BigEndian.PutUint32(n[:], h.Code)
+	frame.Write(n[:4])
+	binary.BigEndian.PutUint32(n[:], uint32(len(h.Details)))
+	frame.Write(n[:4])
+	frame.WriteString(h.Details)
+	binary.BigEndian.PutUint16(n[:], uint16(len(h.Metadata)))
+	frame.Write(n[:2])
+	for k, v := range h.Metadata {
+		if len(k) > 0xffff {
+			return fmt.Errorf("rpc codec: metadata key too long: %.32s", k)
+		}
+		binary.BigEndian.PutUint16(n[:], uint16(len(k)))
+		frame.Write(n[:2])
+		frame.WriteString(k)
+		binary.BigEndian.PutUint32(n[:], uint32(len(v)))
+		frame.Write(n[:4])
+		frame.WriteString(v)
+	}
+	frame.WriteByte(uint8(serializationType))
+	frame.WriteByte(uint8(compressType))
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	frame.Write(n[:4])
+	frame.Write(data)
+	if frame.Len() > MaxFrameSize {
+		return fmt.Errorf("rpc codec: frame too large: %d", frame.Len())
+	}
+
+	defer func() {
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	binary.BigEndian.PutUint32(n[:], uint32(frame.Len()))
+	if _, err = c.buf.Write(n[:4]); err != nil {
+		return err
+	}
+	if _, err = c.buf.Write(frame.Bytes()); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+// frameReader decodes the fixed layout of a frame, remembering the first error.
+type frameReader struct {
+	data []byte
+	err  error
+}
+
+func (r *frameReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *frameReader) uint8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *frameReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *frameReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *frameReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+var (
+	_ FormatCodec = (*FrameCodec)(nil)
+	_ RawCodec    = (*FrameCodec)(nil)
+	_ SizeCodec   = (*FrameCodec)(nil)
+)
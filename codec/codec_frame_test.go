@@ -0,0 +1,73 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error {
+	return nil
+}
+
+var _ io.ReadWriteCloser = (*bufferConn)(nil)
+
+func TestFrameCodec_Write(t *testing.T) {
+	formats := []struct {
+		name              string
+		serializationType int
+		compressType      int
+	}{
+		{"gob+noop", SerializationTypeGob, CompressTypeNoop},
+		{"json+gzip", SerializationTypeJson, CompressTypeGzip},
+		{"xml+snappy", SerializationTypeXml, CompressTypeSnappy},
+		{"json+zlib", SerializationTypeJson, CompressTypeZlib},
+	}
+	for _, tt := range formats {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &bufferConn{}
+			c := NewCodecTypeFrame(conn).(*FrameCodec)
+			c.SetFormat(tt.serializationType, tt.compressType)
+			h := &Header{ServiceMethod: "Foo.Sum", Seq: 7, Timeout: time.Second, Metadata: map[string]string{"request-id": "42"}}
+			if err := c.Write(h, &Person{Name: "longerwu", Age: 23}); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 8, Error: "boom", Code: 5, Details: `[{"type":"t"}]`}, struct{}{}); err != nil {
+				t.Fatal(err)
+			}
+
+			var got Header
+			if err := c.ReadHeader(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(&got, h) {
+				t.Fatalf("ReadHeader() got = %+v, want %+v", got, h)
+			}
+			var p Person
+			if err := c.ReadBody(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != "longerwu" || p.Age != 23 {
+				t.Fatalf("ReadBody() got = %+v", p)
+			}
+			if err := c.ReadHeader(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Seq != 8 || got.Error != "boom" || got.Code != 5 || got.Details != `[{"type":"t"}]` {
+				t.Fatalf("ReadHeader() got = %+v", got)
+			}
+			if err := c.ReadBody(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.ReadHeader(&got); err != io.EOF {
+				t.Fatalf("ReadHeader() err = %v, want EOF", err)
+			}
+		})
+	}
+}
@@ -3,32 +3,44 @@ package codec
 import (
 	"bufio"
 	"encoding/gob"
-	"fmt"
 	"io"
+
+	"github.com/SnDragon/lrpc-go/logger"
 )
 
 type GobCodec struct {
 	conn io.ReadWriteCloser
-	dec  *gob.Decoder
-	enc  *gob.Encoder
-	buf  *bufio.Writer
+	*wireSize
+	dec *gob.Decoder
+	enc *gob.Encoder
+	buf *bufio.Writer
+	log logger.Logger
 }
 
 func NewCodecTypeGob(conn io.ReadWriteCloser) Codec {
-	buf := bufio.NewWriter(conn)
+	ws := newWireSize(conn)
+	buf := bufio.NewWriter(ws.w)
 	return &GobCodec{
-		conn: conn,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(buf),
-		buf:  buf,
+		conn:     conn,
+		wireSize: ws,
+		// countingReader 是 io.ByteReader, gob 不会再套一层缓冲, 计数才准确
+		dec: gob.NewDecoder(ws.r),
+		enc: gob.NewEncoder(buf),
+		buf: buf,
+		log: logger.Default(),
 	}
 }
 
+func (c *GobCodec) SetLogger(l logger.Logger) {
+	c.log = l
+}
+
 func (c GobCodec) Close() error {
 	return c.conn.Close()
 }
 
 func (c GobCodec) ReadHeader(h *Header) error {
+	c.startRead()
 	return c.dec.Decode(h)
 }
 
@@ -37,17 +49,18 @@ func (c GobCodec) ReadBody(b interface{}) error {
 }
 
 func (c GobCodec) Write(h *Header, body interface{}) error {
+	c.startWrite()
 	defer func() {
 		if err := c.buf.Flush(); err != nil {
 			_ = c.Close()
 		}
 	}()
 	if err := c.enc.Encode(h); err != nil {
-		fmt.Println("rpc codec: gob error encoding header:", err)
+		c.log.Error("rpc codec: gob error encoding header", logger.Err(err))
 		return err
 	}
 	if err := c.enc.Encode(body); err != nil {
-		fmt.Println("rpc codec: gob error encoding body:", err)
+		c.log.Error("rpc codec: gob error encoding body", logger.Err(err))
 		return err
 	}
 	return nil
@@ -58,3 +71,6 @@ func (c GobCodec) Write(h *Header, body interface{}) error {
 var _ Codec = (*GobCodec)(nil)
 
 // or var _ Codec = &GobCodec{}
+
+var _ LoggingCodec = (*GobCodec)(nil)
+var _ SizeCodec = (*GobCodec)(nil)
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+// JsonCodec writes every message as one line of JSON:
+//
+//	{"header":{"service_method":"Foo.Sum","seq":1},"body":{"Num1":1,"Num2":2}}
+//
+// so that scripts and tools without gob support can talk to the server.
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	r    *messageLimiter
+	dec  *json.Decoder
+	enc  *json.Encoder
+	buf  *bufio.Writer
+	log  logger.Logger
+	body json.RawMessage // body of the message read by the last ReadHeader
+
+	// sizes of the last messages, see SizeCodec
+	w         *countingWriter
+	readSize  int
+	writeSize int
+}
+
+type jsonMessage struct {
+	Header *Header         `json:"header"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// errMessageTooLarge is returned for a message over MaxFrameSize, like a frame too large.
+var errMessageTooLarge = fmt.Errorf("rpc codec: json message larger than %d bytes", MaxFrameSize)
+
+// maxJsonMessageSize is MaxFrameSize, tests lower it.
+var maxJsonMessageSize int64 = MaxFrameSize
+
+// messageLimiter stops reading at limit, so that a line without end can't grow the decoder without bound.
+type messageLimiter struct {
+	r        io.Reader
+	n, limit int64
+}
+
+func (l *messageLimiter) Read(p []byte) (int, error) {
+	if l.n >= l.limit {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > l.limit-l.n {
+		p = p[:l.limit-l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	return n, err
+}
+
+func NewCodecTypeJson(conn io.ReadWriteCloser) Codec {
+	w := &countingWriter{w: conn}
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	r := &messageLimiter{r: conn}
+	return &JsonCodec{
+		conn: conn,
+		r:    r,
+		dec:  json.NewDecoder(r),
+		enc:  enc,
+		buf:  buf,
+		w:    w,
+		log:  logger.Default(),
+	}
+}
+
+func (c *JsonCodec) SetLogger(l logger.Logger) {
+	c.log = l
+}
+
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	msg := jsonMessage{Header: h}
+	// json.Decoder 自带缓冲, 用 InputOffset 计算消息大小
+	start := c.dec.InputOffset()
+	// 每条消息最多读取 MaxFrameSize 字节, 预读的下一条消息不超过该位置
+	c.r.limit = start + maxJsonMessageSize
+	err := c.dec.Decode(&msg)
+	c.readSize = int(c.dec.InputOffset() - start)
+	if err != nil {
+		return err
+	}
+	c.body = msg.Body
+	return nil
+}
+
+func (c *JsonCodec) ReadSize() int  { return c.readSize }
+func (c *JsonCodec) WriteSize() int { return c.writeSize }
+
+// ReadBody decodes the body kept by ReadHeader. A nil body drains it.
+// Numbers decoded into interface{} are kept as json.Number instead of float64,
+// so integer replies don't lose precision.
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	data := c.body
+	c.body = nil
+	if body == nil || len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(body)
+}
+
+func (c *JsonCodec) ReadRawBody() ([]byte, error) {
+	data := c.body
+	c.body = nil
+	return data, nil
+}
+
+// Write encodes h and body as one line. Error responses and control frames carry no body.
+// A RawBody must hold JSON.
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	start := c.w.n
+	defer func() {
+		if ferr := c.buf.Flush(); ferr != nil {
+			_ = c.Close()
+			if err == nil {
+				err = ferr
+			}
+		}
+		c.writeSize = int(c.w.n - start)
+	}()
+	msg := jsonMessage{Header: h}
+	if raw, ok := body.(RawBody); ok && h.hasBody() {
+		msg.Body = json.RawMessage(raw)
+	} else if h.hasBody() && body != nil {
+		if msg.Body, err = json.Marshal(body); err != nil {
+			c.log.Error("rpc codec: json error encoding body", logger.Err(err))
+			return err
+		}
+	}
+	if err = c.enc.Encode(&msg); err != nil {
+		c.log.Error("rpc codec: json error encoding message", logger.Err(err))
+		return err
+	}
+	return nil
+}
+
+var _ RawCodec = (*JsonCodec)(nil)
+var _ LoggingCodec = (*JsonCodec)(nil)
+var _ SizeCodec = (*JsonCodec)(nil)
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestJsonCodec_ReadBody(t *testing.T) {
+	conn := &bufferConn{}
+	conn.WriteString(`{"header":{"service_method":"Foo.Sum","seq":1},"body":{"name":"longerwu","age":23}}` + "\n")
+	conn.WriteString(`{"header":{"service_method":"Foo.Sum","seq":2},"body":[1,2,3]}` + "\n")
+	conn.WriteString(`{"header":{"service_method":"Foo.Sum","seq":3},"body":9007199254740993}` + "\n")
+	c := NewCodecTypeJson(conn)
+
+	var h Header
+	if err := c.ReadHeader(&h); err != nil || h.Seq != 1 || h.ServiceMethod != "Foo.Sum" {
+		t.Fatalf("ReadHeader() got = %+v, err = %v", h, err)
+	}
+	var p struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	if err := c.ReadBody(&p); err != nil || p.Name != "longerwu" || p.Age != 23 {
+		t.Fatalf("ReadBody() got = %+v, err = %v", p, err)
+	}
+	// drain the body
+	if err := c.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("ReadHeader() got = %+v, err = %v", h, err)
+	}
+	if err := c.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReadHeader(&h); err != nil || h.Seq != 3 {
+		t.Fatalf("ReadHeader() got = %+v, err = %v", h, err)
+	}
+	var reply interface{}
+	if err := c.ReadBody(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := reply.(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Fatalf("ReadBody() got = %#v, want json.Number", reply)
+	}
+}
+
+func TestJsonCodec_Write(t *testing.T) {
+	conn := &bufferConn{}
+	c := NewCodecTypeJson(conn)
+	if err := c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 2, Error: "boom", Code: 5}, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"header":{"service_method":"Foo.Sum","seq":1},"body":3}` + "\n" +
+		`{"header":{"service_method":"Foo.Sum","seq":2,"error":"boom","code":5}}` + "\n"
+	if got := conn.String(); got != want {
+		t.Fatalf("Write() got = %q, want %q", got, want)
+	}
+	if !strings.HasSuffix(conn.String(), "\n") {
+		t.Fatal("expect newline-delimited messages")
+	}
+}
+
+// failingConn fails every write, as a connection closed by the peer.
+type failingConn struct {
+	bufferConn
+}
+
+func (f *failingConn) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestJsonCodec_WriteFlushError(t *testing.T) {
+	c := NewCodecTypeJson(&failingConn{})
+	if err := c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 1}, 3); err == nil {
+		t.Fatal("expect the flush error to be returned")
+	}
+}
+
+// endlessConn reads a message that never ends.
+type endlessConn struct{ bufferConn }
+
+func (c *endlessConn) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = '1'
+	}
+	return len(p), nil
+}
+
+var _ io.ReadWriteCloser = (*endlessConn)(nil)
+
+func TestJsonCodec_tooLarge(t *testing.T) {
+	defer func(size int64) { maxJsonMessageSize = size }(maxJsonMessageSize)
+	maxJsonMessageSize = 1 << 20
+	conn := &bufferConn{}
+	conn.WriteString(`{"header":{"service_method":"Foo.Sum","seq":1},"body":"` + strings.Repeat("x", int(maxJsonMessageSize/2)) + `"}` + "\n")
+	conn.WriteString(`{"header":{"service_method":"Foo.Sum","seq":2},"body":"` + strings.Repeat("x", int(maxJsonMessageSize/2)) + `"}` + "\n")
+	c := NewCodecTypeJson(conn)
+	// the limit applies to each message, not to the connection
+	var h Header
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := c.ReadHeader(&h); err != nil || h.Seq != seq {
+			t.Fatalf("ReadHeader() got = %+v, err = %v", h, err)
+		}
+		_ = c.ReadBody(nil)
+	}
+
+	c = NewCodecTypeJson(&endlessConn{})
+	if err := c.ReadHeader(&h); err != errMessageTooLarge {
+		t.Fatalf("expect errMessageTooLarge, got %v", err)
+	}
+}
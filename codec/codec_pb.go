@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/codec/pb"
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/golang/protobuf/proto"
+)
+
+// ProtoCodec writes every message as two varint-delimited protobuf frames:
+//
+//	| uvarint len | pb.Header | uvarint len | body |
+//
+// Bodies must be proto.Message, error responses and control frames carry an empty body.
+type ProtoCodec struct {
+	conn io.ReadWriteCloser
+	*wireSize
+	buf  *bufio.Writer
+	log  logger.Logger
+	body []byte // body of the message read by the last ReadHeader
+}
+
+func NewCodecTypePB(conn io.ReadWriteCloser) Codec {
+	ws := newWireSize(conn)
+	return &ProtoCodec{
+		conn:     conn,
+		wireSize: ws,
+		buf:      bufio.NewWriter(ws.w),
+		log:      logger.Default(),
+	}
+}
+
+func (c *ProtoCodec) SetLogger(l logger.Logger) {
+	c.log = l
+}
+
+func (c *ProtoCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ProtoCodec) readFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxFrameSize {
+		return nil, fmt.Errorf("rpc codec: frame too large: %d", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *ProtoCodec) writeFrame(frame []byte) error {
+	var n [binary.MaxVarintLen64]byte
+	if _, err := c.buf.Write(n[:binary.PutUvarint(n[:], uint64(len(frame)))]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(frame)
+	return err
+}
+
+func (c *ProtoCodec) ReadHeader(h *Header) error {
+	c.startRead()
+	frame, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	var ph pb.Header
+	if err := proto.Unmarshal(frame, &ph); err != nil {
+		return err
+	}
+	h.Type = FrameType(ph.Type)
+	h.ServiceMethod = ph.ServiceMethod
+	h.Seq = ph.Seq
+	h.Error = ph.Error
+	h.Code = ph.Code
+	h.Details = ph.Details
+	h.Metadata = ph.Metadata
+	h.Timeout = time.Duration(ph.Timeout)
+	c.body, err = c.readFrame()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (c *ProtoCodec) ReadBody(body interface{}) error {
+	data := c.body
+	c.body = nil
+	if body == nil {
+		return nil
+	}
+	msg, ok := body.(proto.Message)
+	if !ok {
+		return fmt.Errorf("rpc codec: pb body %T is not a proto.Message", body)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (c *ProtoCodec) ReadRawBody() ([]byte, error) {
+	data := c.body
+	c.body = nil
+	return data, nil
+}
+
+// Write encodes h and body, a RawBody is taken as an encoded proto.Message.
+func (c *ProtoCodec) Write(h *Header, body interface{}) (err error) {
+	c.startWrite()
+	header, err := proto.Marshal(&pb.Header{
+		Type:          uint32(h.Type),
+		ServiceMethod: h.ServiceMethod,
+		Seq:           h.Seq,
+		Error:         h.Error,
+		Code:          h.Code,
+		Details:       h.Details,
+		Metadata:      h.Metadata,
+		Timeout:       int64(h.Timeout),
+	})
+	if err != nil {
+		c.log.Error("rpc codec: pb error encoding header", logger.Err(err))
+		return err
+	}
+	var data []byte
+	if raw, ok := body.(RawBody); ok && h.hasBody() {
+		data = raw
+	} else if h.hasBody() {
+		msg, ok := body.(proto.Message)
+		if !ok {
+			err = fmt.Errorf("rpc codec: pb body %T is not a proto.Message", body)
+			c.log.Error("rpc codec: pb error encoding body", logger.Err(err))
+			return err
+		}
+		if data, err = proto.Marshal(msg); err != nil {
+			c.log.Error("rpc codec: pb error encoding body", logger.Err(err))
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.writeFrame(header); err != nil {
+		return err
+	}
+	if err = c.writeFrame(data); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+var _ RawCodec = (*ProtoCodec)(nil)
+var _ LoggingCodec = (*ProtoCodec)(nil)
+var _ SizeCodec = (*ProtoCodec)(nil)
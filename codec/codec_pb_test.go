@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/SnDragon/lrpc-go/codec/pb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoCodec_Write(t *testing.T) {
+	conn := &bufferConn{}
+	c := NewCodecTypePB(conn)
+	if err := c.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 1}, wrapperspb.String("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(&Header{ServiceMethod: "Foo.Echo", Seq: 1}, 1); err == nil {
+		t.Fatal("expect an error for non proto.Message body")
+	}
+
+	// the frames must be readable without this package: uvarint len + message
+	data := conn.Bytes()
+	size, n := binary.Uvarint(data)
+	var h pb.Header
+	if err := proto.Unmarshal(data[n:n+int(size)], &h); err != nil || h.ServiceMethod != "Foo.Echo" || h.Seq != 1 {
+		t.Fatalf("header got = %v, err = %v", &h, err)
+	}
+
+	var got Header
+	if err := c.ReadHeader(&got); err != nil {
+		t.Fatal(err)
+	}
+	reply := &wrapperspb.StringValue{}
+	if err := c.ReadBody(reply); err != nil || reply.Value != "hello" {
+		t.Fatalf("ReadBody() got = %q, err = %v", reply.Value, err)
+	}
+}
@@ -1,7 +1,13 @@
 package codec
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
 
+// Compressor compresses the bodies of frames. Decompress must reject outputs
+// larger than MaxFrameSize, see readDecompressed.
 type Compressor interface {
 	Compress(in []byte) (out []byte, err error)
 	Decompress(in []byte) (out []byte, err error)
@@ -40,8 +46,30 @@ func Decompress(compressType int, in []byte) (out []byte, err error) {
 		return nil, nil
 	}
 	compressor := GetCompressor(compressType)
-	if compressor != nil {
+	if compressor == nil {
 		return nil, fmt.Errorf("compressor not registered")
 	}
-	return compressor.Decompress(in)
+	out, err = compressor.Decompress(in)
+	if err == nil && int64(len(out)) > maxDecompressedSize {
+		return nil, errDecompressedTooLarge
+	}
+	return out, err
+}
+
+var errDecompressedTooLarge = fmt.Errorf("rpc codec: decompressed body larger than %d bytes", MaxFrameSize)
+
+// maxDecompressedSize is MaxFrameSize, tests lower it.
+var maxDecompressedSize int64 = MaxFrameSize
+
+// readDecompressed reads the output of a decompressor up to MaxFrameSize bytes,
+// so that a small frame can't expand without bound.
+func readDecompressed(r io.Reader) ([]byte, error) {
+	out, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > maxDecompressedSize {
+		return nil, errDecompressedTooLarge
+	}
+	return out, nil
 }
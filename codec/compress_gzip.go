@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"compress/gzip"
 	"fmt"
-	"io/ioutil"
 )
 
 func init() {
@@ -35,5 +34,5 @@ func (g GzipCompressor) Decompress(in []byte) (out []byte, err error) {
 		return nil, fmt.Errorf("gzip Decompress erorr: %buf", err)
 	}
 	defer reader.Close()
-	return ioutil.ReadAll(reader)
+	return readDecompressed(reader)
 }
@@ -23,3 +23,24 @@ func TestGzipCompress_Compress(t *testing.T) {
 	fmt.Println(string(o))
 
 }
+
+func TestDecompress_limit(t *testing.T) {
+	defer func(size int64) { maxDecompressedSize = size }(maxDecompressedSize)
+	maxDecompressedSize = 1 << 20
+	data := make([]byte, maxDecompressedSize+1)
+	for _, compressType := range []int{CompressTypeGzip, CompressTypeSnappy, CompressTypeZlib} {
+		out, err := Compress(compressType, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decompress(compressType, out); err != errDecompressedTooLarge {
+			t.Errorf("compress type %d: expect the body to be rejected, got %v", compressType, err)
+		}
+		if out, err = Compress(compressType, data[:maxDecompressedSize]); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := Decompress(compressType, out); err != nil || int64(len(got)) != maxDecompressedSize {
+			t.Errorf("compress type %d: expect %d bytes, got %d, err: %v", compressType, maxDecompressedSize, len(got), err)
+		}
+	}
+}
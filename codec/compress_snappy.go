@@ -3,7 +3,6 @@ package codec
 import (
 	"bytes"
 	"github.com/golang/snappy"
-	"io/ioutil"
 )
 
 func init() {
@@ -27,5 +26,5 @@ func (s SnappyCompressor) Compress(in []byte) ([]byte, error) {
 
 func (s SnappyCompressor) Decompress(in []byte) (out []byte, err error) {
 	r := snappy.NewReader(bytes.NewReader(in))
-	return ioutil.ReadAll(r)
+	return readDecompressed(r)
 }
@@ -3,7 +3,6 @@ package codec
 import (
 	"bytes"
 	"compress/zlib"
-	"io"
 )
 
 func init() {
@@ -26,14 +25,9 @@ func (z ZlibCompressor) Compress(in []byte) (out []byte, err error) {
 }
 
 func (z ZlibCompressor) Decompress(in []byte) ([]byte, error) {
-	b := bytes.NewReader(in)
-	var out bytes.Buffer
-	r, err := zlib.NewReader(b)
+	r, err := zlib.NewReader(bytes.NewReader(in))
 	if err != nil {
 		return nil, err
 	}
-	if _, err := io.Copy(&out, r); err != nil {
-		return nil, err
-	}
-	return out.Bytes(), nil
+	return readDecompressed(r)
 }
@@ -0,0 +1,73 @@
+package codec
+
+import (
+	"bufio"
+	"io"
+)
+
+// SizeCodec is implemented by codecs that count the bytes of their messages on the wire, e.g. for metrics.
+// Like ReadHeader and Write, each method must not be called concurrently with itself.
+type SizeCodec interface {
+	Codec
+	// ReadSize returns the bytes read since the start of the last ReadHeader,
+	// the size of the message once its body is read.
+	ReadSize() int
+	// WriteSize returns the bytes of the message written by the last Write.
+	WriteSize() int
+}
+
+// countingReader counts the bytes read through a bufio.Reader, it is an io.ByteReader
+// so that decoders read from it without buffering on their own.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+// countingWriter counts the bytes written to the connection. The codecs flush every
+// message, so the bytes counted during a Write are the size of its message.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// wireSize implements SizeCodec for the codecs embedding it.
+type wireSize struct {
+	r          *countingReader
+	w          *countingWriter
+	readStart  int64
+	writeStart int64
+}
+
+// newWireSize returns the reader and writer a codec must use on conn.
+func newWireSize(conn io.ReadWriter) *wireSize {
+	return &wireSize{
+		r: &countingReader{r: bufio.NewReader(conn)},
+		w: &countingWriter{w: conn},
+	}
+}
+
+func (s *wireSize) startRead()  { s.readStart = s.r.n }
+func (s *wireSize) startWrite() { s.writeStart = s.w.n }
+
+func (s *wireSize) ReadSize() int  { return int(s.r.n - s.readStart) }
+func (s *wireSize) WriteSize() int { return int(s.w.n - s.writeStart) }
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSizeCodec(t *testing.T) {
+	codecs := []struct {
+		name  string
+		new   NewCodecType
+		body  func() interface{}
+		slack int // bytes left unread after the last message
+	}{
+		{"gob", NewCodecTypeGob, func() interface{} { return &Person{} }, 0},
+		{"json", NewCodecTypeJson, func() interface{} { return &Person{} }, 1}, // trailing newline
+		{"frame", NewCodecTypeFrame, func() interface{} { return &Person{} }, 0},
+		{"pb", NewCodecTypePB, func() interface{} { return &wrapperspb.StringValue{} }, 0},
+	}
+	for _, tt := range codecs {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &bufferConn{}
+			c := tt.new(conn).(SizeCodec)
+			var body interface{} = &Person{Name: "longerwu", Age: 23}
+			if tt.name == "pb" {
+				body = wrapperspb.String("longerwu")
+			}
+			written := 0
+			for seq := uint64(1); seq <= 2; seq++ {
+				before := conn.Len()
+				if err := c.Write(&Header{ServiceMethod: "Foo.Sum", Seq: seq}, body); err != nil {
+					t.Fatal(err)
+				}
+				if got := c.WriteSize(); got != conn.Len()-before || got == 0 {
+					t.Fatalf("WriteSize() = %d, wrote %d", got, conn.Len()-before)
+				}
+				written += c.WriteSize()
+			}
+			read := 0
+			for i := 0; i < 2; i++ {
+				var h Header
+				if err := c.ReadHeader(&h); err != nil {
+					t.Fatal(err)
+				}
+				if err := c.ReadBody(tt.body()); err != nil {
+					t.Fatal(err)
+				}
+				read += c.ReadSize()
+			}
+			if read != written-tt.slack {
+				t.Fatalf("read %d bytes, expect %d", read, written-tt.slack)
+			}
+		})
+	}
+}
@@ -7,4 +7,4 @@ require (
 	github.com/golang/snappy v0.0.4
 )
 
-require google.golang.org/protobuf v1.26.0 // indirect
+require google.golang.org/protobuf v1.26.0
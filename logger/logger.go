@@ -0,0 +1,179 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of an entry, a Logger drops the entries below its level.
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff // drops every entry
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	case LevelOff:
+		return "OFF"
+	}
+	return "Level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Field is a key/value pair attached to an entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Uint64(key string, value uint64) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns the field "err", or an empty field skipped by the Logger if err is nil.
+func Err(err error) Field {
+	if err == nil {
+		return Field{}
+	}
+	return Field{Key: "err", Value: err}
+}
+
+// Logger writes leveled entries with structured fields.
+// Implementations must be safe for concurrent use, adapt another logging
+// library by implementing it.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With returns a Logger adding fields to every entry.
+	With(fields ...Field) Logger
+}
+
+// textLogger writes one line per entry: time level msg key=value...
+type textLogger struct {
+	mu     *sync.Mutex // shared with the loggers returned by With
+	w      io.Writer
+	level  Level
+	fields []Field
+}
+
+// New returns a Logger writing the entries of level and above to w as text lines.
+func New(w io.Writer, level Level) Logger {
+	return &textLogger{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+// Nop returns a Logger dropping every entry.
+func Nop() Logger {
+	return New(io.Discard, LevelOff)
+}
+
+func (l *textLogger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *textLogger) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *textLogger) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *textLogger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+func (l *textLogger) With(fields ...Field) Logger {
+	c := *l
+	c.fields = append(append([]Field(nil), l.fields...), fields...)
+	return &c
+}
+
+func (l *textLogger) log(level Level, msg string, fields []Field) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(time.Now().Format("2006/01/02 15:04:05.000000"))
+	b.WriteByte(' ')
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, fs := range [][]Field{l.fields, fields} {
+		for _, f := range fs {
+			if f.Key == "" {
+				continue
+			}
+			b.WriteByte(' ')
+			b.WriteString(f.Key)
+			b.WriteByte('=')
+			b.WriteString(quote(fmt.Sprint(f.Value)))
+		}
+	}
+	b.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, b.String())
+}
+
+// quote quotes s if it would not read as a single value.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// holder lets atomic.Value store Loggers of different types.
+type holder struct{ Logger }
+
+var defaultLogger atomic.Value
+
+func init() {
+	SetDefault(New(os.Stderr, LevelInfo))
+}
+
+// Default returns the Logger of the components not given one, it writes the
+// entries of LevelInfo and above to stderr unless replaced with SetDefault.
+func Default() Logger {
+	return defaultLogger.Load().(holder).Logger
+}
+
+// SetDefault replaces the default Logger, e.g. with Nop() to silence the package.
+// Components already created keep the Logger they got.
+func SetDefault(l Logger) {
+	defaultLogger.Store(holder{l})
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying l, the server hands every method
+// a context carrying a Logger with the seq, method and peer of the call.
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the Logger of ctx, or Default() if there is none.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return Default()
+}
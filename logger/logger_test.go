@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogger_levels(t *testing.T) {
+	var buf strings.Builder
+	l := New(&buf, LevelInfo)
+	l.Debug("dropped")
+	l.Info("kept")
+	l.Error("failed", Err(errors.New("boom")), Err(nil))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 entries, got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], " INFO kept") || !strings.HasSuffix(lines[1], " ERROR failed err=boom") {
+		t.Fatalf("unexpected entries %q", lines)
+	}
+
+	buf.Reset()
+	Nop().Error("dropped")
+	New(&buf, LevelOff).Error("dropped")
+	if buf.Len() != 0 {
+		t.Fatalf("expect nothing logged, got %q", buf.String())
+	}
+}
+
+func TestLogger_fields(t *testing.T) {
+	var buf strings.Builder
+	l := New(&buf, LevelDebug).With(String("peer", "127.0.0.1:1"))
+	l.With(Uint64("seq", 7)).Debug("call", String("reason", `not "found"`), Any("n", 3))
+	l.Debug("conn")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if want := ` DEBUG call peer=127.0.0.1:1 seq=7 reason="not \"found\"" n=3`; !strings.HasSuffix(lines[0], want) {
+		t.Fatalf("expect %q, got %q", want, lines[0])
+	}
+	if want := " DEBUG conn peer=127.0.0.1:1"; !strings.HasSuffix(lines[1], want) {
+		t.Fatalf("expect With not to leak fields, got %q", lines[1])
+	}
+}
+
+func TestLogger_context(t *testing.T) {
+	if FromContext(context.Background()) != Default() {
+		t.Fatal("expect the default logger without one in the context")
+	}
+	l := Nop()
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Fatal("expect the logger of the context")
+	}
+	old := Default()
+	defer SetDefault(old)
+	SetDefault(l)
+	if Default() != l {
+		t.Fatal("expect SetDefault to replace the default logger")
+	}
+}
@@ -45,7 +45,7 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	if err != nil {
 		panic(err)
 	}
-	registry.Heartbeat(registryAddr, "tcp@"+lis.Addr().String(), 0)
+	registry.Heartbeat(registryAddr, "tcp@"+lis.Addr().String(), 0, s.Services()...)
 	wg.Done()
 	if err := s.Accept(lis); err != nil {
 		panic(err)
@@ -97,7 +97,8 @@ func broadcast(registry string) {
 			defer wg.Done()
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
 			// expect 2 - 5 timeout
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			defer cancel()
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
 		}(i)
 	}
@@ -0,0 +1,84 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+)
+
+// MD is the string key/value metadata carried by codec.Header,
+// e.g. request ids, tenant ids or auth tokens.
+type MD map[string]string
+
+func New(m map[string]string) MD {
+	md := MD{}
+	for k, v := range m {
+		md[k] = v
+	}
+	return md
+}
+
+// Pairs returns an MD built from key, value pairs.
+// It panics if len(kv) is odd.
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got the odd number of input pairs: %d", len(kv)))
+	}
+	md := MD{}
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+func (md MD) Get(k string) string {
+	return md[k]
+}
+
+func (md MD) Set(k, v string) {
+	md[k] = v
+}
+
+func (md MD) Copy() MD {
+	return New(md)
+}
+
+// Join merges mds into a new MD, later values win.
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+
+// NewOutgoingContext attaches md to ctx, it will be sent by client.Client.Call.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+// AppendToOutgoingContext returns a context with kv merged into its outgoing metadata.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+// NewIncomingContext is used by the server to hand the request metadata to handlers.
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+// FromIncomingContext returns the metadata sent by the caller.
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
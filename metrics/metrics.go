@@ -0,0 +1,208 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the upper bounds of latency histograms, in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count upper bounds, the first is start and each next one is factor times bigger.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// family is a metric with all its label values.
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64 // histograms only
+
+	mu     sync.RWMutex
+	series map[string]*series // by joined label values
+}
+
+// series is one set of label values of a family.
+type series struct {
+	value  uint64 // float64 bits, counters and gauges. 放在最前面, 保证 32 位平台上 8 字节对齐
+	values []string
+
+	mu      sync.Mutex // histograms only
+	counts  []uint64   // per bucket, not cumulative, the last one is +Inf
+	sum     float64
+	samples uint64
+}
+
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s := f.series[key]
+	f.mu.RUnlock()
+	if s != nil {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s = f.series[key]; s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&s.value)
+		if atomic.CompareAndSwapUint64(&s.value, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (s *series) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.value))
+}
+
+// Registry holds metric families and writes them in the Prometheus text format.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// DefaultRegistry is used by servers and clients not given one.
+var DefaultRegistry = NewRegistry()
+
+// family returns the family name, created on first use so that several servers or
+// clients share it. It panics if name is already registered with another kind or labels.
+func (r *Registry) family(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered as %s%v", name, f.kind, f.labels))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  append([]string(nil), labels...),
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*series),
+	}
+	sort.Float64s(f.buckets)
+	r.families[name] = f
+	return f
+}
+
+// CounterVec is a counter with labels, only going up.
+type CounterVec struct{ f *family }
+
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.family(name, help, kindCounter, nil, labels)}
+}
+
+// Counter is a CounterVec with label values.
+type Counter struct{ s *series }
+
+// With returns the counter of the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) Counter {
+	return Counter{v.f.with(values)}
+}
+
+func (c Counter) Inc() { c.s.add(1) }
+
+// Add adds v, which must not be negative.
+func (c Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.s.add(v)
+}
+
+func (c Counter) Value() float64 { return c.s.load() }
+
+// GaugeVec is a value with labels, going up and down.
+type GaugeVec struct{ f *family }
+
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.family(name, help, kindGauge, nil, labels)}
+}
+
+// Gauge is a GaugeVec with label values.
+type Gauge struct{ s *series }
+
+func (v *GaugeVec) With(values ...string) Gauge {
+	return Gauge{v.f.with(values)}
+}
+
+func (g Gauge) Inc()           { g.s.add(1) }
+func (g Gauge) Dec()           { g.s.add(-1) }
+func (g Gauge) Add(v float64)  { g.s.add(v) }
+func (g Gauge) Set(v float64)  { atomic.StoreUint64(&g.s.value, math.Float64bits(v)) }
+func (g Gauge) Value() float64 { return g.s.load() }
+
+// HistogramVec counts observations in buckets, with labels.
+type HistogramVec struct{ f *family }
+
+// Histogram registers a histogram with the upper bounds buckets, +Inf is implied.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.family(name, help, kindHistogram, buckets, labels)}
+}
+
+// Histogram is a HistogramVec with label values.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+func (v *HistogramVec) With(values ...string) Histogram {
+	return Histogram{v.f.with(values), v.f.buckets}
+}
+
+func (h Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v) // first bucket with v <= bound
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	h.s.counts[i]++
+	h.s.sum += v
+	h.s.samples++
+}
+
+// Count returns the number of observations.
+func (h Histogram) Count() uint64 {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	return h.s.samples
+}
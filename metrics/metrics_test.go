@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("rpc_requests_total", "Requests.", "method", "code")
+	requests.With("Foo.Sum", "OK").Inc()
+	requests.With("Foo.Sum", "OK").Add(2)
+	requests.With("Foo.Sum", "Internal").Inc()
+	conns := r.Gauge("rpc_connections", "Open connections.").With()
+	conns.Inc()
+	conns.Inc()
+	conns.Dec()
+	latency := r.Histogram("rpc_duration_seconds", "Latency.", []float64{0.1, 1}, "method")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With(`a"b\`).Observe(v)
+	}
+	r.Counter("rpc_unused_total", "Never incremented.")
+
+	var b strings.Builder
+	if err := r.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP rpc_connections Open connections.
+# TYPE rpc_connections gauge
+rpc_connections 1
+# HELP rpc_duration_seconds Latency.
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{method="a\"b\\",le="0.1"} 2
+rpc_duration_seconds_bucket{method="a\"b\\",le="1"} 3
+rpc_duration_seconds_bucket{method="a\"b\\",le="+Inf"} 4
+rpc_duration_seconds_sum{method="a\"b\\"} 3.65
+rpc_duration_seconds_count{method="a\"b\\"} 4
+# HELP rpc_requests_total Requests.
+# TYPE rpc_requests_total counter
+rpc_requests_total{method="Foo.Sum",code="Internal"} 1
+rpc_requests_total{method="Foo.Sum",code="OK"} 3
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") || w.Body.String() != want {
+		t.Fatalf("unexpected response %q: %s", ct, w.Body.String())
+	}
+}
+
+func TestRegistry_shared(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("calls_total", "Calls.", "method").With("a").Inc()
+	// a second server or client recording in the same registry gets the same family
+	if got := r.Counter("calls_total", "Calls.", "method").With("a").Value(); got != 1 {
+		t.Fatalf("expect the counter to be shared, got %v", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a panic for a family registered with other labels")
+		}
+	}()
+	r.Gauge("calls_total", "Calls.", "method", "code")
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePrometheus writes every family in the Prometheus text exposition format, sorted by name.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics of r to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.RLock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.RUnlock()
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+	w.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+	w.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+	for _, s := range all {
+		labels := f.labelPairs(s.values)
+		if f.kind != kindHistogram {
+			writeSample(w, f.name, labels, s.load())
+			continue
+		}
+		s.mu.Lock()
+		counts, sum, samples := append([]uint64(nil), s.counts...), s.sum, s.samples
+		s.mu.Unlock()
+		var cumulative uint64
+		bounds := append(append([]float64(nil), f.buckets...), math.Inf(1))
+		for i, bound := range bounds {
+			cumulative += counts[i]
+			le := `le="` + formatFloat(bound) + `"`
+			writeSample(w, f.name+"_bucket", append(labels[:len(labels):len(labels)], le), float64(cumulative))
+		}
+		writeSample(w, f.name+"_sum", labels, sum)
+		writeSample(w, f.name+"_count", labels, float64(samples))
+	}
+}
+
+func (f *family) labelPairs(values []string) []string {
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = f.labels[i] + `="` + escapeLabel(v) + `"`
+	}
+	return pairs
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+func escapeHelp(v string) string  { return helpEscaper.Replace(v) }
@@ -1,24 +1,27 @@
 package registry
 
 import (
-	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/SnDragon/lrpc-go/logger"
 )
 
 type Registry struct {
 	timeout time.Duration // 超时时间,注册的服务超过该时间,视为不可用
+	log     logger.Logger
 	mu      sync.Mutex
 	servers map[string]*ServerItem
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr     string
+	Services []string // services reported by the heartbeat, empty if unknown
+	start    time.Time
 }
 
 const (
@@ -30,51 +33,72 @@ func New(timeout time.Duration) *Registry {
 	return &Registry{
 		servers: make(map[string]*ServerItem),
 		timeout: timeout,
+		log:     logger.Default(),
 	}
 }
 
+// SetLogger sets the Logger of r, logger.Default() if not set. It must be called before r serves.
+func (r *Registry) SetLogger(l logger.Logger) {
+	r.log = l
+}
+
 var DefaultRegister = New(DefaultTimeout)
 
-func (r *Registry) putServer(addr string) {
+func (r *Registry) putServer(addr string, services []string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	s := r.servers[addr]
 	if s == nil {
 		r.servers[addr] = &ServerItem{
-			Addr:  addr,
-			start: time.Now(),
+			Addr:     addr,
+			Services: services,
+			start:    time.Now(),
 		}
 	} else {
+		s.Services = services
 		s.start = time.Now()
 	}
 }
 
-func (r *Registry) aliveServers() []string {
+func (r *Registry) aliveServers() []*ServerItem {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
+	var alive []*ServerItem
 	for _, server := range r.servers {
 		if r.timeout == 0 || server.start.Add(DefaultTimeout).After(time.Now()) {
-			alive = append(alive, server.Addr)
+			alive = append(alive, &ServerItem{Addr: server.Addr, Services: server.Services})
 		} else {
 			delete(r.servers, server.Addr)
 		}
 	}
-	sort.Strings(alive)
+	sort.Slice(alive, func(i, j int) bool { return alive[i].Addr < alive[j].Addr })
 	return alive
 }
 
 func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-LRPC-Servers", strings.Join(r.aliveServers(), ","))
+		alive := r.aliveServers()
+		addrs := make([]string, 0, len(alive))
+		for _, s := range alive {
+			addrs = append(addrs, s.Addr)
+			if len(s.Services) > 0 {
+				// 每个服务端一行: addr service1,service2
+				w.Header().Add("X-LRPC-Server-Services", s.Addr+" "+strings.Join(s.Services, ","))
+			}
+		}
+		w.Header().Set("X-LRPC-Servers", strings.Join(addrs, ","))
 	case "POST":
 		addr := req.Header.Get("X-LRPC-Server")
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		var services []string
+		if v := req.Header.Get("X-LRPC-Services"); v != "" {
+			services = strings.Split(v, ",")
+		}
+		r.putServer(addr, services)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -82,39 +106,56 @@ func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (r *Registry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
-	log.Println("rpc registry path:", registryPath)
+	r.log.Info("rpc registry: path", logger.String("path", registryPath))
 }
 
 func HandleHTTP() {
 	DefaultRegister.HandleHTTP(DefaultPath)
 }
 
-func Heartbeat(registry, addr string, duration time.Duration) {
+// Heartbeat registers addr to the registry now and then every duration.
+// services, e.g. from server.Server.Services, let XClient route calls by service.
+// It logs with logger.Default(), see HeartbeatWithLogger.
+func Heartbeat(registry, addr string, duration time.Duration, services ...string) {
+	HeartbeatWithLogger(logger.Default(), registry, addr, duration, services...)
+}
+
+// HeartbeatWithLogger is Heartbeat logging with log.
+func HeartbeatWithLogger(log logger.Logger, registry, addr string, duration time.Duration, services ...string) {
 	if duration == 0 {
 		duration = DefaultTimeout - time.Duration(1)*time.Minute
 	}
-	if err := sendHeartbeat(registry, addr); err != nil {
-		fmt.Println("Heartbeat sendHeartbeat err:", err)
+	log = log.With(logger.String("registry", registry), logger.String("addr", addr))
+	if err := sendHeartbeat(log, registry, addr, services); err != nil {
+		log.Warn("rpc registry: send heartbeat", logger.Err(err))
 	}
 	go func() {
 		ticker := time.NewTicker(duration)
 		for {
 			<-ticker.C
-			if err := sendHeartbeat(registry, addr); err != nil {
-				fmt.Println("Heartbeat sendHeartbeat err:", err)
+			if err := sendHeartbeat(log, registry, addr, services); err != nil {
+				log.Warn("rpc registry: send heartbeat", logger.Err(err))
 			}
 		}
 	}()
 }
 
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
+func sendHeartbeat(log logger.Logger, registry, addr string, services []string) error {
+	log.Debug("rpc registry: send heartbeat")
 	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
+	req, err := http.NewRequest("POST", registry, nil)
+	if err != nil {
+		return err
+	}
 	req.Header.Set("X-LRPC-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
+	if len(services) > 0 {
+		req.Header.Set("X-LRPC-Services", strings.Join(services, ","))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
 		return err
 	}
-	return nil
+	// 读完并关闭响应, 连接才能复用
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
 }
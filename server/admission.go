@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+// errTooManyConns is the handshake rejection reason once MaxConns is reached.
+var errTooManyConns = errors.New("rpc server: too many connections")
+
+// admission holds the limits set by the admission ServerOptions, the zero value admits everything.
+type admission struct {
+	// rejected peers, shown on the debug page.
+	// 放在最前面, 保证 32 位平台上 atomic 操作 8 字节对齐
+	numTooManyConns int64
+	numDenied       int64
+
+	maxConns    int // 0 means no limit
+	maxInflight int // per connection, 0 means no limit
+	allow, deny []*net.IPNet
+}
+
+// WithMaxConns limits the number of connections served at the same time,
+// the handshake of any further connection is rejected. n <= 0 means no limit.
+func WithMaxConns(n int) ServerOption {
+	return func(s *Server) {
+		s.admission.maxConns = n
+	}
+}
+
+// WithMaxInflight limits the number of calls handled at the same time on one connection.
+// A call counts until its method returns, even if it was answered with a timeout.
+// Once the limit is hit up to n further calls wait for a slot, in order, and the calls
+// beyond are rejected with ErrOverloaded. The connection keeps being read meanwhile, so
+// cancel frames and the connection closing are still seen. n <= 0 means no limit.
+func WithMaxInflight(n int) ServerOption {
+	return func(s *Server) {
+		s.admission.maxInflight = n
+	}
+}
+
+// WithAllowCIDRs only accepts connections from the given networks, e.g. "10.0.0.0/8".
+// It panics if a cidr can't be parsed.
+func WithAllowCIDRs(cidrs ...string) ServerOption {
+	nets := mustParseCIDRs(cidrs)
+	return func(s *Server) {
+		s.admission.allow = append(s.admission.allow, nets...)
+	}
+}
+
+// WithDenyCIDRs refuses connections from the given networks, it takes precedence over WithAllowCIDRs.
+// It panics if a cidr can't be parsed.
+func WithDenyCIDRs(cidrs ...string) ServerOption {
+	nets := mustParseCIDRs(cidrs)
+	return func(s *Server) {
+		s.admission.deny = append(s.admission.deny, nets...)
+	}
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("rpc server: invalid cidr %q: %v", cidr, err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// allowed checks addr against the deny and allow lists.
+// Addresses without an IP, e.g. unix sockets, are only refused by a non-empty allow list.
+func (a *admission) allowed(addr net.Addr) bool {
+	if len(a.allow) == 0 && len(a.deny) == 0 {
+		return true
+	}
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	default:
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+	if ip == nil {
+		return len(a.allow) == 0
+	}
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit reports whether a connection from addr may be served, counting the denied ones.
+func (s *Server) admit(addr net.Addr) bool {
+	if s.admission.allowed(addr) {
+		return true
+	}
+	atomic.AddInt64(&s.admission.numDenied, 1)
+	s.log.Info("rpc server: deny connection", logger.String("peer", addr.String()))
+	return false
+}
+
+// NumTooManyConns returns the number of connections rejected by WithMaxConns.
+func (s *Server) NumTooManyConns() int64 {
+	return atomic.LoadInt64(&s.admission.numTooManyConns)
+}
+
+// NumDenied returns the number of connections refused by the CIDR lists.
+func (s *Server) NumDenied() int64 {
+	return atomic.LoadInt64(&s.admission.numDenied)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAdmission_allowed(t *testing.T) {
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234} }
+	unix := &net.UnixAddr{Name: "/tmp/lrpc.sock", Net: "unix"}
+	cases := []struct {
+		name        string
+		allow, deny []string
+		addr        net.Addr
+		want        bool
+	}{
+		{"no lists", nil, nil, tcp("8.8.8.8"), true},
+		{"allowed", []string{"10.0.0.0/8"}, nil, tcp("10.1.2.3"), true},
+		{"not allowed", []string{"10.0.0.0/8"}, nil, tcp("192.168.0.1"), false},
+		{"denied", nil, []string{"192.168.0.0/16"}, tcp("192.168.0.1"), false},
+		{"not denied", nil, []string{"192.168.0.0/16"}, tcp("10.1.2.3"), true},
+		{"deny wins", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, tcp("10.1.2.3"), false},
+		{"ipv6", []string{"::1/128"}, nil, tcp("::1"), true},
+		{"unix with deny list", nil, []string{"10.0.0.0/8"}, unix, true},
+		{"unix with allow list", []string{"10.0.0.0/8"}, nil, unix, false},
+	}
+	for _, c := range cases {
+		s := NewServer(WithAllowCIDRs(c.allow...), WithDenyCIDRs(c.deny...))
+		if got := s.admission.allowed(c.addr); got != c.want {
+			t.Errorf("%s: allowed(%s) = %v, want %v", c.name, c.addr, got, c.want)
+		}
+	}
+}
+
+func TestWithAllowCIDRs_invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect a panic for an invalid cidr")
+		}
+	}()
+	WithAllowCIDRs("10.0.0.1")
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+// ErrConnNotFound is returned by CloseConnection for an unknown or closed connection.
+var ErrConnNotFound = errors.New("rpc server: connection not found")
+
+// ConnInfo is a snapshot of a connection served by a Server, see Server.Connections.
+type ConnInfo struct {
+	ID         uint64     `json:"id"`
+	RemoteAddr string     `json:"remote_addr"`
+	Option     Option     `json:"option"` // negotiated in the handshake, Option.CodecType is the codec
+	Connected  time.Time  `json:"connected"`
+	BytesIn    uint64     `json:"bytes_in"` // read after the handshake
+	BytesOut   uint64     `json:"bytes_out"`
+	GoingAway  bool       `json:"going_away"`
+	Calls      []CallInfo `json:"calls"` // in flight, by seq
+}
+
+// CallInfo is a call in flight on a connection.
+type CallInfo struct {
+	Seq     uint64        `json:"seq"`
+	Method  string        `json:"method"`
+	Started time.Time     `json:"started"` // when its header was read
+	Age     time.Duration `json:"age_ns"`
+}
+
+// countingConn counts the bytes read and written on a connection.
+type countingConn struct {
+	read, written uint64 // first fields, 64-bit aligned for atomic on 32-bit platforms
+	net.Conn
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddUint64(&c.written, uint64(n))
+	return n, err
+}
+
+// serverConn is the server side of a connection after the handshake.
+type serverConn struct {
+	id        uint64 // set by trackConn
+	connected time.Time
+	conn      net.Conn
+	counter   *countingConn // wraps conn for the codec
+	codec     codec.Codec
+	peer      *Peer
+	log       logger.Logger // with the peer field
+	sending   sync.Mutex    // guards writes to codec
+	calls     *inflightCalls
+	slots     chan struct{} // limits the calls in flight, nil means no limit
+	waiting   chan struct{} // limits the calls waiting for a slot, see enqueue
+
+	mu        sync.Mutex
+	goingAway bool // GOAWAY sent, new requests are rejected
+}
+
+func newServerConn(conn net.Conn, opt *Option, maxInflight int, log logger.Logger) *serverConn {
+	counter := &countingConn{Conn: conn}
+	sc := &serverConn{
+		connected: time.Now(),
+		conn:      conn,
+		counter:   counter,
+		codec:     codec.CodecTypeMap[opt.CodecType](counter),
+		peer:      &Peer{Addr: conn.RemoteAddr(), Option: *opt},
+		log:       log,
+		calls:     newInflightCalls(),
+	}
+	if lc, ok := sc.codec.(codec.LoggingCodec); ok {
+		lc.SetLogger(log)
+	}
+	if maxInflight > 0 {
+		sc.slots = make(chan struct{}, maxInflight)
+		sc.waiting = make(chan struct{}, maxInflight)
+	}
+	return sc
+}
+
+// requestLogger returns the Logger of the request of h.
+func (sc *serverConn) requestLogger(h *codec.Header) logger.Logger {
+	return sc.log.With(logger.Uint64("seq", h.Seq), logger.String("method", h.ServiceMethod))
+}
+
+// tryAcquire takes a slot for the next call without blocking. It fails while the
+// connection is at its limit, or while calls wait for a slot so that they go first.
+func (sc *serverConn) tryAcquire() bool {
+	if sc.slots == nil {
+		return true
+	}
+	if len(sc.waiting) > 0 {
+		return false
+	}
+	select {
+	case sc.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueue lets a call wait for a slot with waitSlot, it fails if too many calls are waiting.
+func (sc *serverConn) enqueue() bool {
+	select {
+	case sc.waiting <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitSlot takes a slot for a call queued by enqueue, it returns ctx.Err() if ctx is done first.
+func (sc *serverConn) waitSlot(ctx context.Context) error {
+	defer func() { <-sc.waiting }()
+	select {
+	case sc.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release returns the slot taken by tryAcquire or waitSlot.
+func (sc *serverConn) release() {
+	if sc.slots != nil {
+		<-sc.slots
+	}
+}
+
+// goAway tells the client to stop sending new calls on the connection,
+// calls read afterwards are rejected with ErrServerClosed.
+func (sc *serverConn) goAway() {
+	sc.mu.Lock()
+	if sc.goingAway {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	sc.mu.Unlock()
+	if sc.peer.Option.Features&FeatureGoAway == 0 {
+		return
+	}
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	if err := sc.codec.Write(&codec.Header{Type: codec.FrameTypeGoAway}, invalidRequest); err != nil {
+		sc.log.Warn("rpc server: send goaway", logger.Err(err))
+	}
+}
+
+// startCall tracks the call of req, unless GOAWAY has been sent.
+func (sc *serverConn) startCall(req *Request, cancel context.CancelFunc) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.goingAway {
+		return false
+	}
+	sc.calls.add(req.h.Seq, req.h.ServiceMethod, req.start, cancel)
+	return true
+}
+
+// drained reports whether GOAWAY has been sent and no call is in flight.
+func (sc *serverConn) drained() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.goingAway && sc.calls.len() == 0
+}
+
+// trackListener adds or removes lis from the listeners closed by Shutdown,
+// it fails once the server is shutting down.
+func (s *Server) trackListener(lis net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.shuttingDown {
+			return false
+		}
+		s.listeners[lis] = struct{}{}
+	} else {
+		delete(s.listeners, lis)
+	}
+	return true
+}
+
+// trackConn adds sc to the connections drained by Shutdown,
+// it fails once the server is shutting down or has reached WithMaxConns.
+func (s *Server) trackConn(sc *serverConn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return ErrServerClosed
+	}
+	if s.admission.maxConns > 0 && len(s.conns) >= s.admission.maxConns {
+		atomic.AddInt64(&s.admission.numTooManyConns, 1)
+		return errTooManyConns
+	}
+	s.nextConnID++
+	sc.id = s.nextConnID
+	s.conns[sc] = struct{}{}
+	s.metrics.conns.Inc()
+	s.metrics.connsTotal.Inc()
+	return nil
+}
+
+func (s *Server) untrackConn(sc *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, sc)
+	s.metrics.conns.Dec()
+}
+
+func (sc *serverConn) info(now time.Time) ConnInfo {
+	sc.mu.Lock()
+	goingAway := sc.goingAway
+	sc.mu.Unlock()
+	return ConnInfo{
+		ID:         sc.id,
+		RemoteAddr: sc.peer.Addr.String(),
+		Option:     sc.peer.Option,
+		Connected:  sc.connected,
+		BytesIn:    atomic.LoadUint64(&sc.counter.read),
+		BytesOut:   atomic.LoadUint64(&sc.counter.written),
+		GoingAway:  goingAway,
+		Calls:      sc.calls.snapshot(now),
+	}
+}
+
+// Connections returns a snapshot of the connections being served, by ID.
+func (s *Server) Connections() []ConnInfo {
+	s.mu.Lock()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+	now := time.Now()
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, sc := range conns {
+		infos = append(infos, sc.info(now))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// CloseConnection closes the connection id of Connections right away,
+// its calls in flight are cancelled and not answered.
+func (s *Server) CloseConnection(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.conns {
+		if sc.id == id {
+			sc.log.Warn("rpc server: connection closed by admin")
+			return sc.conn.Close()
+		}
+	}
+	return ErrConnNotFound
+}
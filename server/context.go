@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/SnDragon/lrpc-go/metadata"
+)
+
+// Peer describes the client on the other end of a connection.
+type Peer struct {
+	Addr   net.Addr
+	Option Option // negotiated in the handshake
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the connection a method is called on.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+type trailerKey struct{}
+
+// trailer collects the metadata a handler sends back with its response.
+type trailer struct {
+	mu sync.Mutex
+	md metadata.MD
+}
+
+func (t *trailer) get() metadata.MD {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.md
+}
+
+// newRequestContext returns the context handed to a method, carrying the incoming metadata.
+func newRequestContext(ctx context.Context, req *Request) (context.Context, *trailer) {
+	t := &trailer{}
+	ctx = metadata.NewIncomingContext(ctx, metadata.New(req.h.Metadata))
+	return context.WithValue(ctx, trailerKey{}, t), t
+}
+
+// SetTrailer sets metadata sent back to the caller with the response,
+// multiple calls are merged. ctx must be the context passed to the method.
+func SetTrailer(ctx context.Context, md metadata.MD) error {
+	t, ok := ctx.Value(trailerKey{}).(*trailer)
+	if !ok {
+		return errors.New("rpc server: SetTrailer called outside of a method")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.md = metadata.Join(t.md, md)
+	return nil
+}
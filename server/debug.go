@@ -1,56 +1,352 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/codec"
 )
 
 const debugText = `<html>
+	<head>
+	<title>LRPC Services</title>
+	<style>
+	th { cursor: pointer; }
+	td.num { text-align: right; }
+	</style>
+	<script>
+	// 点击表头按该列排序, 再次点击反向
+	function sortTable(th) {
+		var table = th.closest("table"), col = th.cellIndex;
+		var rows = Array.from(table.rows).slice(1);
+		var asc = th.dataset.order !== "asc";
+		th.dataset.order = asc ? "asc" : "desc";
+		rows.sort(function(a, b) {
+			var x = a.cells[col].dataset.value || a.cells[col].textContent;
+			var y = b.cells[col].dataset.value || b.cells[col].textContent;
+			var d = (isNaN(x) || isNaN(y)) ? x.localeCompare(y) : x - y;
+			return asc ? d : -d;
+		});
+		rows.forEach(function(r) { table.tBodies[0].appendChild(r); });
+	}
+	</script>
+	</head>
 	<body>
-	<title>GeeRPC Services</title>
-	{{range .}}
+	Rejected connections: {{.NumTooManyConns}} over the limit, {{.NumDenied}} denied by address.
+	<a href="?format=json">JSON</a> <a href="{{.MetricsPath}}">Metrics</a>
+	{{range .Namespaces}}
+	{{if .Name}}<h3>Namespace {{.Name}}</h3>{{end}}
+	{{range $svc := .Services}}
 	<hr>
-	Service {{.Name}}
+	Service {{.Name}}{{if .Version}} version {{.Version}}{{end}}{{if .Default}} (default){{end}}, registered {{.Registered.Format "2006-01-02 15:04:05"}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<tr>
+		<th onclick="sortTable(this)">Method</th><th onclick="sortTable(this)">Calls</th><th onclick="sortTable(this)">Errors</th>
+		<th onclick="sortTable(this)">Rate limited</th><th onclick="sortTable(this)">Panics</th>
+		<th onclick="sortTable(this)">Avg latency</th><th onclick="sortTable(this)">P99 latency</th>
+		</tr>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed data-value="{{.Name}}">{{if .Traced}}<a href="?trace={{$svc.Name}}.{{.Name}}&version={{$svc.Version}}">{{.Signature}}</a>{{else}}{{.Signature}}{{end}}</td>
+			<td class=num>{{.Calls}}</td>
+			<td class=num>{{.Errors}}</td>
+			<td class=num>{{.RateLimited}}</td>
+			<td class=num>{{.Panics}}</td>
+			<td class=num data-value="{{.AvgLatency.Nanoseconds}}">{{.AvgLatency}}</td>
+			<td class=num data-value="{{.P99Latency.Nanoseconds}}">{{.P99Latency}}</td>
 			</tr>
 		{{end}}
 		</table>
+		{{if .Skipped}}
+		Skipped methods:
+		<ul>
+		{{range .Skipped}}<li>{{.Name}}: {{.Reason}}</li>{{end}}
+		</ul>
+		{{end}}
+	{{end}}
 	{{end}}
+	<hr>
+	<h3>Connections</h3>
+	<table>
+	<tr>
+	<th onclick="sortTable(this)">ID</th><th onclick="sortTable(this)">Remote address</th><th onclick="sortTable(this)">Codec</th>
+	<th onclick="sortTable(this)">Connected</th><th onclick="sortTable(this)">Bytes in</th><th onclick="sortTable(this)">Bytes out</th>
+	<th onclick="sortTable(this)">Calls in flight</th><th></th>
+	</tr>
+	{{range .Connections}}
+		<tr>
+		<td class=num>{{.ID}}</td>
+		<td>{{.RemoteAddr}}{{if .GoingAway}} (going away){{end}}</td>
+		<td>{{codecName .Option.CodecType}}</td>
+		<td data-value="{{.Connected.Unix}}">{{.Connected.Format "2006-01-02 15:04:05"}}</td>
+		<td class=num>{{.BytesIn}}</td>
+		<td class=num>{{.BytesOut}}</td>
+		<td data-value="{{len .Calls}}">{{range .Calls}}{{.Method}} seq={{.Seq}} age={{.Age}}<br>{{end}}</td>
+		<td>{{if $.Admin}}<form method="post"><input type="hidden" name="kill" value="{{.ID}}"><input type="submit" value="Kill"></form>{{end}}</td>
+		</tr>
+	{{end}}
+	</table>
 	</body>
 	</html>`
 
-var debug = template.Must(template.New("RPC debug").Parse(debugText))
+const debugTraceText = `<html>
+	<head>
+	<title>LRPC Traces {{.Method}}</title>
+	</head>
+	<body>
+	<a href="?">Services</a> <a href="?trace={{.Method}}&version={{.Version}}&format=json">JSON</a>
+	<h3>{{.Method}}{{if .Version}} version {{.Version}}{{end}}</h3>
+	{{template "traces" dict "Title" "Recent" "Traces" .Recent}}
+	{{template "traces" dict "Title" "Slowest" "Traces" .Slowest}}
+	{{template "traces" dict "Title" "Failed" "Traces" .Failed}}
+	</body>
+	</html>
+{{define "traces"}}
+	<h4>{{.Title}}</h4>
+	<table>
+	<tr><th>Start</th><th>Duration</th><th>Seq</th><th>Peer</th><th>Code</th><th>Args</th><th>Reply or error</th></tr>
+	{{range .Traces}}
+		<tr>
+		<td>{{.Start.Format "15:04:05.000000"}}</td>
+		<td align=right>{{.Duration}}</td>
+		<td align=right>{{.Seq}}</td>
+		<td>{{.Peer}}</td>
+		<td>{{.Code}}</td>
+		<td><code>{{.Args}}</code></td>
+		<td><code>{{if .Error}}{{.Error}}{{else}}{{.Reply}}{{end}}</code></td>
+		</tr>
+	{{end}}
+	</table>
+{{end}}`
+
+var debug = template.Must(template.New("RPC debug").Funcs(template.FuncMap{"codecName": codecName}).Parse(debugText))
+
+var codecNames = map[codec.CodecType]string{
+	codec.CodecTypeGob:   "gob",
+	codec.CodecTypeJson:  "json",
+	codec.CodecTypeFrame: "frame",
+	codec.CodecTypePB:    "pb",
+}
+
+func codecName(t codec.CodecType) string {
+	if name, ok := codecNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(int(t))
+}
+
+var debugTraces = template.Must(template.New("RPC traces").Funcs(template.FuncMap{"dict": dict}).Parse(debugTraceText))
+
+// dict passes several values to a nested template, from key and value pairs.
+func dict(kv ...interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		m[kv[i].(string)] = kv[i+1]
+	}
+	return m
+}
 
 type debugHTTP struct {
 	*Server
 }
 
+// WithDebugAdmin lets the page at DefaultDebugPath close connections, with a POST of kill=<id>.
+// Only enable it where the debug page is not reachable by untrusted clients.
+func WithDebugAdmin() ServerOption {
+	return func(s *Server) {
+		s.debugAdmin = true
+	}
+}
+
+// debugPage is rendered as HTML, or as JSON with ?format=json.
+type debugPage struct {
+	Namespaces      []debugNamespace `json:"namespaces"`
+	NumTooManyConns int64            `json:"num_too_many_conns"`
+	NumDenied       int64            `json:"num_denied"`
+	Connections     []ConnInfo       `json:"connections"`
+	MetricsPath     string           `json:"-"`
+	Admin           bool             `json:"-"` // see WithDebugAdmin
+}
+
+type debugNamespace struct {
+	Name     string         `json:"name"` // empty for services registered without a namespace
+	Services []debugService `json:"services"`
+}
+
 type debugService struct {
-	Name   string
-	Method map[string]*methodType
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	Default    bool           `json:"default,omitempty"` // the default of several versions
+	Registered time.Time      `json:"registered"`
+	Methods    []debugMethod  `json:"methods"`
+	Skipped    []debugSkipped `json:"skipped,omitempty"`
 }
 
-// Runs at /debug/geerpc
-func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	// Build a sorted version of the data.
-	var services []debugService
-	server.serviceMap.Range(func(namei, svci interface{}) bool {
-		svc := svci.(*service)
-		services = append(services, debugService{
-			Name:   namei.(string),
-			Method: svc.methods,
+type debugMethod struct {
+	Name        string        `json:"name"`
+	Signature   string        `json:"signature"`
+	Calls       uint64        `json:"calls"`
+	Errors      uint64        `json:"errors"`
+	RateLimited uint64        `json:"rate_limited"`
+	Panics      uint64        `json:"panics"`
+	AvgLatency  time.Duration `json:"avg_latency_ns"`
+	P99Latency  time.Duration `json:"p99_latency_ns"` // approximate, see latencyStats
+	Traced      bool          `json:"traced"`         // see WithTraces
+}
+
+// debugTracePage lists the traces of a method, at ?trace=Service.Method&version=v.
+type debugTracePage struct {
+	Method  string   `json:"method"`
+	Version string   `json:"version,omitempty"`
+	Recent  []*trace `json:"recent"`
+	Slowest []*trace `json:"slowest"`
+	Failed  []*trace `json:"failed"`
+}
+
+// debugSkipped is an exported method that doesn't meet the RPC conditions.
+type debugSkipped struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func newDebugService(svc *service) debugService {
+	ds := debugService{Name: svc.name, Version: svc.version, Registered: svc.registered}
+	for name, m := range svc.methods {
+		ds.Methods = append(ds.Methods, debugMethod{
+			Name:        name,
+			Signature:   m.signature(),
+			Calls:       atomic.LoadUint64(&m.NumCalls),
+			Errors:      atomic.LoadUint64(&m.NumErrors),
+			RateLimited: atomic.LoadUint64(&m.NumRateLimited),
+			Panics:      atomic.LoadUint64(&m.NumPanics),
+			AvgLatency:  m.latency.mean(),
+			P99Latency:  m.latency.quantile(0.99),
+			Traced:      m.traces != nil,
 		})
+	}
+	sort.Slice(ds.Methods, func(i, j int) bool { return ds.Methods[i].Name < ds.Methods[j].Name })
+	for name, reason := range svc.skipped {
+		ds.Skipped = append(ds.Skipped, debugSkipped{Name: name, Reason: reason})
+	}
+	sort.Slice(ds.Skipped, func(i, j int) bool { return ds.Skipped[i].Name < ds.Skipped[j].Name })
+	return ds
+}
+
+// Runs at DefaultDebugPath, a POST with kill=<id> closes the connection id if WithDebugAdmin is set.
+func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		server.kill(w, req)
+		return
+	}
+	if method := req.URL.Query().Get("trace"); method != "" {
+		server.traces(w, req, method)
+		return
+	}
+	// Build a sorted version of the data, grouped by namespace.
+	var namespaces []debugNamespace
+	var names []string
+	server.serviceMap.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
 		return true
 	})
-	err := debug.Execute(w, services)
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool { return namespace(names[i]) < namespace(names[j]) })
+	for _, name := range names {
+		sv := server.loadVersions(name)
+		if sv == nil {
+			continue
+		}
+		ns := namespace(name)
+		if len(namespaces) == 0 || namespaces[len(namespaces)-1].Name != ns {
+			namespaces = append(namespaces, debugNamespace{Name: ns})
+		}
+		last := &namespaces[len(namespaces)-1]
+		for _, v := range sv.sorted() {
+			ds := newDebugService(sv.versions[v])
+			ds.Default = len(sv.versions) > 1 && v == sv.def
+			last.Services = append(last.Services, ds)
+		}
+	}
+	page := debugPage{
+		Namespaces:      namespaces,
+		NumTooManyConns: server.NumTooManyConns(),
+		NumDenied:       server.NumDenied(),
+		Connections:     server.Connections(),
+		MetricsPath:     DefaultMetricsPath,
+		Admin:           server.debugAdmin,
+	}
+	render(w, req, debug, page)
+}
+
+// render writes page as JSON with ?format=json, otherwise with tmpl.
+func render(w http.ResponseWriter, req *http.Request, tmpl *template.Template, page interface{}) {
+	if req.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(page); err != nil {
+			_, _ = fmt.Fprintln(w, "rpc: error encoding json:", err.Error())
+		}
+		return
+	}
+	err := tmpl.Execute(w, page)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+func (server debugHTTP) traces(w http.ResponseWriter, req *http.Request, method string) {
+	version := req.URL.Query().Get("version")
+	_, m, err := server.findService(method, version)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if m.traces == nil {
+		http.Error(w, "rpc: "+method+" is not traced, see WithTraces", http.StatusNotFound)
+		return
+	}
+	page := debugTracePage{Method: method, Version: version}
+	page.Recent, page.Slowest, page.Failed = m.traces.snapshot()
+	render(w, req, debugTraces, page)
+}
+
+func (server debugHTTP) kill(w http.ResponseWriter, req *http.Request) {
+	if !server.debugAdmin {
+		http.Error(w, "rpc: closing connections is disabled, see WithDebugAdmin", http.StatusMethodNotAllowed)
+		return
+	}
+	// 拒绝其它站点的页面提交的表单
+	if !sameOrigin(req) {
+		http.Error(w, "rpc: cross-origin request", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.ParseUint(req.FormValue("kill"), 10, 64)
+	if err != nil {
+		http.Error(w, "rpc: invalid connection id", http.StatusBadRequest)
+		return
+	}
+	if err := server.CloseConnection(id); err == ErrConnNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+}
+
+// sameOrigin reports whether req comes from a page of the same host, or from a client not sending Origin, e.g. curl.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
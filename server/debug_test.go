@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SnDragon/lrpc-go/codec"
+)
+
+func TestDebugHTTP(t *testing.T) {
+	s := NewServer()
+	var foo Foo
+	if err := s.Register(&foo); err != nil {
+		t.Fatal(err)
+	}
+	svc, m, _ := s.findService("Foo.Sum", "")
+	for i := 0; i < 3; i++ {
+		_, _ = svc.call(context.Background(), m, m.newArgv(), m.newReplyv())
+	}
+	_, pm, _ := s.findService("Foo.Panic", "")
+	_, _ = s.invoke(context.Background(), &Request{h: &codec.Header{ServiceMethod: "Foo.Panic"}, svr: svc, mType: pm, argv: pm.newArgv(), replyv: pm.newReplyv()})
+
+	w := httptest.NewRecorder()
+	debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath+"?format=json", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expect json, got %q", ct)
+	}
+	var page debugPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Namespaces) != 1 || len(page.Namespaces[0].Services) != 1 {
+		t.Fatalf("expect one service, got %+v", page.Namespaces)
+	}
+	ds := page.Namespaces[0].Services[0]
+	if ds.Name != "Foo" || ds.Registered.IsZero() || len(ds.Skipped) != 4 || ds.Skipped[0].Name != "NoError" {
+		t.Fatalf("unexpected service %+v", ds)
+	}
+	methods := make(map[string]debugMethod)
+	for _, dm := range ds.Methods {
+		methods[dm.Name] = dm
+	}
+	if sum := methods["Sum"]; sum.Calls != 3 || sum.Errors != 0 || sum.P99Latency <= 0 || sum.AvgLatency > sum.P99Latency {
+		t.Fatalf("unexpected Sum stats %+v", sum)
+	}
+	if p := methods["Panic"]; p.Calls != 1 || p.Errors != 1 || p.Panics != 1 {
+		t.Fatalf("unexpected Panic stats %+v", p)
+	}
+
+	w = httptest.NewRecorder()
+	debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	if body := w.Body.String(); !strings.Contains(body, "LRPC Services") || !strings.Contains(body, "NotPointer") {
+		t.Fatalf("unexpected page: %s", body)
+	}
+}
+
+func TestDebugHTTP_kill(t *testing.T) {
+	post := func(s *Server, form, origin string) int {
+		r := httptest.NewRequest("POST", DefaultDebugPath, strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		debugHTTP{s}.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post(NewServer(), "kill=42", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect %d without WithDebugAdmin, got %d", http.StatusMethodNotAllowed, code)
+	}
+	w := httptest.NewRecorder()
+	debugHTTP{NewServer()}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	if strings.Contains(w.Body.String(), "Kill") {
+		t.Fatal("expect no kill button without WithDebugAdmin")
+	}
+
+	s := NewServer(WithDebugAdmin())
+	for _, c := range []struct {
+		form, origin string
+		code         int
+	}{
+		{"kill=x", "", http.StatusBadRequest},
+		{"kill=42", "", http.StatusNotFound},
+		{"kill=42", "http://example.com", http.StatusNotFound}, // httptest.NewRequest is for example.com
+		{"kill=42", "http://evil.example", http.StatusForbidden},
+	} {
+		if code := post(s, c.form, c.origin); code != c.code {
+			t.Fatalf("%s from %q: expect %d, got %d", c.form, c.origin, c.code, code)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/SnDragon/lrpc-go/codec"
+)
+
+/*
+握手协议
+client -> server: | MagicNumber uint32 | handshakeFlag|Version uint32 | len uint32 | Option(json) |
+server -> client: | len uint32 | HandshakeReply(json) |
+
+老版本客户端只发送 | MagicNumber uint32 | CodecType uint32 |, 服务端不回包。
+CodecType 不会设置最高位, 以此区分两种握手。
+*/
+
+// ProtocolVersion is the newest handshake version this package speaks.
+const ProtocolVersion uint32 = 1
+
+const (
+	handshakeFlag    uint32 = 1 << 31
+	maxHandshakeSize        = 64 << 10
+)
+
+// Option.Features flags, a client must only rely on the ones accepted in HandshakeReply.Features.
+const (
+	// FeatureCancel lets the client send codec.FrameTypeCancel for abandoned calls.
+	FeatureCancel uint32 = 1 << iota
+	// FeatureGoAway lets the server send codec.FrameTypeGoAway before it shuts down.
+	FeatureGoAway
+)
+
+// supportedFeatures are the Option.Features the server agrees to.
+var supportedFeatures = FeatureCancel | FeatureGoAway
+
+// HandshakeReply is the server's answer to a versioned handshake.
+type HandshakeReply struct {
+	Version  uint32 `json:"version"` // negotiated version
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"` // why the handshake was rejected
+	Features uint32 `json:"features"`         // features enabled on the connection
+}
+
+// ErrHandshakeRejected is wrapped by the error returned when the server rejects a handshake.
+var ErrHandshakeRejected = errors.New("rpc: handshake rejected")
+
+func writeFrame(w io.Writer, buf *bytes.Buffer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+	if size > maxHandshakeSize {
+		return fmt.Errorf("rpc: handshake too large: %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteHandshake sends opt to the server in a versioned handshake.
+func WriteHandshake(w io.Writer, opt *Option) error {
+	buf := bytes.NewBuffer([]byte{})
+	_ = binary.Write(buf, binary.BigEndian, opt.MagicNumber)
+	_ = binary.Write(buf, binary.BigEndian, handshakeFlag|opt.Version)
+	return writeFrame(w, buf, opt)
+}
+
+// ReadHandshakeReply reads the server's answer to WriteHandshake.
+// A rejected handshake is reported as an error wrapping ErrHandshakeRejected.
+func ReadHandshakeReply(r io.Reader) (*HandshakeReply, error) {
+	var reply HandshakeReply
+	if err := readFrame(r, &reply); err != nil {
+		return nil, err
+	}
+	if !reply.Accepted {
+		return &reply, fmt.Errorf("%w: %s", ErrHandshakeRejected, reply.Reason)
+	}
+	return &reply, nil
+}
+
+func writeHandshakeReply(w io.Writer, reply *HandshakeReply) error {
+	return writeFrame(w, bytes.NewBuffer([]byte{}), reply)
+}
+
+// readHandshake reads the handshake of either a versioned or a legacy client.
+func readHandshake(r io.Reader) (opt *Option, versioned bool, err error) {
+	var words [2]uint32
+	if err = binary.Read(r, binary.BigEndian, &words); err != nil {
+		return nil, false, err
+	}
+	if words[1]&handshakeFlag == 0 {
+		opt = &Option{
+			MagicNumber:       words[0],
+			CodecType:         codec.CodecType(words[1]),
+			SerializationType: DefaultOption.SerializationType,
+			CompressType:      DefaultOption.CompressType,
+		}
+		return opt, false, nil
+	}
+	opt = &Option{}
+	if err = readFrame(r, opt); err != nil {
+		return nil, true, err
+	}
+	opt.MagicNumber = words[0]
+	opt.Version = words[1] &^ handshakeFlag
+	return opt, true, nil
+}
+
+// checkOption validates the option sent by a client and negotiates its version and features.
+func checkOption(opt *Option) error {
+	if opt.MagicNumber != MagicNumber {
+		return fmt.Errorf("invalid magicNumber: %v", opt.MagicNumber)
+	}
+	if codec.CodecTypeMap[opt.CodecType] == nil {
+		return fmt.Errorf("invalid codecType: %v", opt.CodecType)
+	}
+	if codec.GetSerializer(opt.SerializationType) == nil {
+		return fmt.Errorf("invalid serializationType: %v", opt.SerializationType)
+	}
+	if codec.GetCompressor(opt.CompressType) == nil {
+		return fmt.Errorf("invalid compressType: %v", opt.CompressType)
+	}
+	if opt.HandleTimeout < 0 {
+		return fmt.Errorf("invalid handleTimeout: %v", opt.HandleTimeout)
+	}
+	if opt.Version > ProtocolVersion {
+		opt.Version = ProtocolVersion
+	}
+	opt.Features &= supportedFeatures
+	return nil
+}
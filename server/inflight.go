@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// inflightCalls tracks the calls being handled on a connection by seq,
+// so that the client can cancel them.
+type inflightCalls struct {
+	mu    sync.Mutex
+	calls map[uint64]*inflightCall
+}
+
+type inflightCall struct {
+	method string
+	start  time.Time
+	cancel context.CancelFunc
+}
+
+func newInflightCalls() *inflightCalls {
+	return &inflightCalls{calls: make(map[uint64]*inflightCall)}
+}
+
+func (ic *inflightCalls) add(seq uint64, method string, start time.Time, cancel context.CancelFunc) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	ic.calls[seq] = &inflightCall{method: method, start: start, cancel: cancel}
+}
+
+// cancel cancels the context of the call seq, it's a no-op if the call has finished.
+func (ic *inflightCalls) cancel(seq uint64) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if call, ok := ic.calls[seq]; ok {
+		call.cancel()
+	}
+}
+
+// done removes seq once its call has finished.
+func (ic *inflightCalls) done(seq uint64) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if call, ok := ic.calls[seq]; ok {
+		call.cancel()
+		delete(ic.calls, seq)
+	}
+}
+
+func (ic *inflightCalls) len() int {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	return len(ic.calls)
+}
+
+// snapshot returns the calls in flight by seq, with their age at now.
+func (ic *inflightCalls) snapshot(now time.Time) []CallInfo {
+	ic.mu.Lock()
+	calls := make([]CallInfo, 0, len(ic.calls))
+	for seq, call := range ic.calls {
+		calls = append(calls, CallInfo{Seq: seq, Method: call.method, Started: call.start, Age: now.Sub(call.start)})
+	}
+	ic.mu.Unlock()
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Seq < calls[j].Seq })
+	return calls
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/metadata"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// UnaryServerInfo describes the call an interceptor wraps.
+type UnaryServerInfo struct {
+	ServiceMethod string // `service.method`
+	Service       string
+	Method        string
+	Version       string      // version of the service, see WithVersion
+	Metadata      metadata.MD // incoming metadata
+	Peer          *Peer
+}
+
+// UnaryHandler invokes the method, or the next interceptor of the chain.
+// args must have the args type of the method.
+type UnaryHandler func(ctx context.Context, args interface{}) (reply interface{}, err error)
+
+// UnaryServerInterceptor wraps every method invocation. It may inspect or replace args,
+// call handler, and inspect or replace the reply and error sent back to the caller.
+type UnaryServerInterceptor func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (reply interface{}, err error)
+
+// WithInterceptors appends interceptors to the chain of the server,
+// the first one is the outermost.
+func WithInterceptors(interceptors ...UnaryServerInterceptor) ServerOption {
+	return func(s *Server) {
+		s.interceptors = append(s.interceptors, interceptors...)
+	}
+}
+
+// chainInterceptors folds interceptors into one, nil if there is none.
+func chainInterceptors(interceptors []UnaryServerInterceptor) UnaryServerInterceptor {
+	if len(interceptors) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		var next func(i int) UnaryHandler
+		next = func(i int) UnaryHandler {
+			if i == len(interceptors) {
+				return handler
+			}
+			return func(ctx context.Context, args interface{}) (interface{}, error) {
+				return interceptors[i](ctx, args, info, next(i+1))
+			}
+		}
+		return next(0)(ctx, args)
+	}
+}
+
+// invoke calls the method of req through the interceptor chain. A panic of the method
+// or of an interceptor is turned into ErrInternal, unless WithCrashOnPanic is set.
+func (s *Server) invoke(ctx context.Context, req *Request) (reply interface{}, err error) {
+	info := newServerInfo(ctx, req)
+	var numPanics *uint64
+	if req.mType != nil {
+		numPanics = &req.mType.NumPanics
+	}
+	defer recoverCall(ctx, info.Service, info.Method, numPanics, s.crashOnPanic, &err)
+	if req.svr == nil {
+		return s.invokeUnknown(ctx, req, info)
+	}
+	if s.interceptor == nil {
+		replyv, err := req.svr.call(ctx, req.mType, req.argv, req.replyv)
+		return replyv.Interface(), err
+	}
+	handler := func(ctx context.Context, args interface{}) (interface{}, error) {
+		argv := reflect.ValueOf(args)
+		if !argv.IsValid() || argv.Type() != req.mType.ArgType {
+			return nil, status.Errorf(status.Internal, "rpc server: interceptor passed args of type %T to %s, want %s", args, req.h.ServiceMethod, req.mType.ArgType)
+		}
+		replyv, err := req.svr.call(ctx, req.mType, argv, req.replyv)
+		return replyv.Interface(), err
+	}
+	return s.interceptor(ctx, req.argv.Interface(), info, handler)
+}
+
+// invokeUnknown calls the UnknownServiceHandler through the interceptor chain,
+// args and reply are codec.RawBody.
+func (s *Server) invokeUnknown(ctx context.Context, req *Request, info *UnaryServerInfo) (interface{}, error) {
+	if s.unknownService == nil {
+		return nil, status.Errorf(status.NotFound, "server err: serviceMethod %s not found", req.h.ServiceMethod)
+	}
+	handler := func(ctx context.Context, args interface{}) (interface{}, error) {
+		body, ok := args.(codec.RawBody)
+		if !ok {
+			return nil, status.Errorf(status.Internal, "rpc server: interceptor passed args of type %T to %s, want codec.RawBody", args, req.h.ServiceMethod)
+		}
+		raw, err := s.unknownService(ctx, req.h.ServiceMethod, body)
+		return codec.RawBody(raw), err
+	}
+	if s.interceptor == nil {
+		return handler(ctx, codec.RawBody(req.raw))
+	}
+	return s.interceptor(ctx, codec.RawBody(req.raw), info, handler)
+}
+
+func newServerInfo(ctx context.Context, req *Request) *UnaryServerInfo {
+	md, _ := metadata.FromIncomingContext(ctx)
+	p, _ := PeerFromContext(ctx)
+	info := &UnaryServerInfo{
+		ServiceMethod: req.h.ServiceMethod,
+		Metadata:      md,
+		Peer:          p,
+	}
+	if req.svr != nil {
+		info.Service, info.Method, info.Version = req.svr.name, req.mType.method.Name, req.svr.version
+	} else if i := strings.LastIndex(req.h.ServiceMethod, "."); i >= 0 {
+		info.Service, info.Method = req.h.ServiceMethod[:i], req.h.ServiceMethod[i+1:]
+	}
+	return info
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/metadata"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+func TestServer_invoke(t *testing.T) {
+	var trace []string
+	logging := func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		trace = append(trace, "before "+info.ServiceMethod+" "+info.Metadata.Get("user"))
+		reply, err := handler(ctx, args)
+		trace = append(trace, "after")
+		return reply, err
+	}
+	double := func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		a := args.(Args)
+		return handler(ctx, Args{Num1: a.Num1 * 2, Num2: a.Num2 * 2})
+	}
+	auth := func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		if info.Metadata.Get("user") == "" {
+			return nil, errors.New("unauthenticated")
+		}
+		return handler(ctx, args)
+	}
+	s := NewServer(WithInterceptors(logging, auth), WithInterceptors(double))
+	var foo Foo
+	if err := s.Register(&foo); err != nil {
+		t.Fatal(err)
+	}
+	newRequest := func() *Request {
+		svr, mType, _ := s.findService("Foo.Sum", "")
+		req := &Request{h: &codec.Header{ServiceMethod: "Foo.Sum"}, svr: svr, mType: mType}
+		req.argv, req.replyv = mType.newArgv(), mType.newReplyv()
+		req.argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 2}))
+		return req
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("user", "longerwu"))
+	reply, err := s.invoke(ctx, newRequest())
+	if err != nil || *reply.(*int) != 6 {
+		t.Fatalf("expect 6, got %v, err: %v", reply, err)
+	}
+	if strings.Join(trace, ",") != "before Foo.Sum longerwu,after" {
+		t.Fatalf("unexpected trace %v", trace)
+	}
+	if _, err := s.invoke(context.Background(), newRequest()); err == nil || err.Error() != "unauthenticated" {
+		t.Fatalf("expect unauthenticated, got %v", err)
+	}
+
+	// a panicking interceptor fails the call instead of the process
+	panicking := func(ctx context.Context, args interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		panic("interceptor")
+	}
+	s = NewServer(WithInterceptors(panicking))
+	_ = s.Register(&foo)
+	if _, err := s.invoke(context.Background(), newRequest()); !errors.Is(err, ErrInternal) {
+		t.Fatalf("expect ErrInternal, got %v", err)
+	}
+}
+
+func TestServer_unexpectedFrame(t *testing.T) {
+	s := NewServer(WithCrashOnPanic(), WithLogger(logger.Nop()))
+	var foo Foo
+	_ = s.Register(&foo)
+	cli, srv := net.Pipe()
+	defer func() { _ = cli.Close() }()
+	go s.ServeConn(srv)
+	opt := DefaultOption
+	opt.CodecType = codec.CodecTypeJson
+	if err := WriteHandshake(cli, &opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHandshakeReply(cli); err != nil {
+		t.Fatal(err)
+	}
+	c := codec.NewCodecTypeJson(cli)
+	go func() {
+		_ = c.Write(&codec.Header{Type: codec.FrameTypeGoAway, Seq: 1}, nil)
+		_ = c.Write(&codec.Header{Type: 7, ServiceMethod: "Foo.Sum", Seq: 2}, nil)
+		_ = c.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 3}, Args{Num1: 1, Num2: 2})
+	}()
+	// only the call is answered
+	var h codec.Header
+	var reply int
+	if err := c.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReadBody(&reply); err != nil || h.Seq != 3 || h.Error != "" || reply != 3 {
+		t.Fatalf("expect the reply of seq 3, got %+v, %d, err: %v", h, reply, err)
+	}
+
+	// without an UnknownServiceHandler an unknown call is not found
+	_, err := s.invoke(context.Background(), &Request{h: &codec.Header{ServiceMethod: "Bar.Sum"}})
+	if status.CodeOf(err) != status.NotFound {
+		t.Fatalf("expect NotFound, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"time"
+
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/metrics"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// WithMetrics records the metrics of the server in r instead of metrics.DefaultRegistry.
+func WithMetrics(r *metrics.Registry) ServerOption {
+	return func(s *Server) {
+		s.metrics = newServerMetrics(r)
+	}
+}
+
+// SizeBuckets are the upper bounds of the request and response size histograms, 64B to 16MB.
+var SizeBuckets = metrics.ExponentialBuckets(64, 4, 10)
+
+// unknownMethod is the method label of the calls not resolved to a registered method,
+// so that clients can't blow up the number of series with made up names.
+const unknownMethod = "unknown"
+
+type serverMetrics struct {
+	registry          *metrics.Registry
+	requests          *metrics.CounterVec   // method, code
+	duration          *metrics.HistogramVec // method
+	inflight          *metrics.GaugeVec     // method
+	requestBytes      *metrics.HistogramVec // method
+	responseBytes     *metrics.HistogramVec // method
+	conns             metrics.Gauge
+	connsTotal        metrics.Counter
+	handshakeFailures *metrics.CounterVec // reason
+}
+
+func newServerMetrics(r *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		registry: r,
+		requests: r.Counter("lrpc_server_requests_total",
+			"Requests handled by the server, by method and status code.", "method", "code"),
+		duration: r.Histogram("lrpc_server_request_duration_seconds",
+			"Time from reading a request to sending its response.", metrics.DefaultBuckets, "method"),
+		inflight: r.Gauge("lrpc_server_requests_in_flight",
+			"Requests read and not answered yet.", "method"),
+		requestBytes: r.Histogram("lrpc_server_request_bytes",
+			"Size of the requests on the wire.", SizeBuckets, "method"),
+		responseBytes: r.Histogram("lrpc_server_response_bytes",
+			"Size of the responses on the wire.", SizeBuckets, "method"),
+		conns: r.Gauge("lrpc_server_connections",
+			"Connections open after the handshake.").With(),
+		connsTotal: r.Counter("lrpc_server_connections_total",
+			"Connections accepted after the handshake.").With(),
+		handshakeFailures: r.Counter("lrpc_server_handshake_failures_total",
+			"Handshakes failed or rejected, by reason: read, option or rejected.", "reason"),
+	}
+}
+
+func (r *Request) methodLabel() string {
+	if r.mType == nil {
+		return unknownMethod
+	}
+	return r.h.ServiceMethod
+}
+
+// begin counts req in flight, size is the bytes of the request or -1 if unknown.
+func (m *serverMetrics) begin(req *Request, size int) {
+	method := req.methodLabel()
+	m.inflight.With(method).Inc()
+	if size >= 0 {
+		m.requestBytes.With(method).Observe(float64(size))
+	}
+}
+
+// end records the outcome of req, size is the bytes of the response or -1 if none was sent.
+func (m *serverMetrics) end(req *Request, code status.Code, size int) {
+	method := req.methodLabel()
+	m.inflight.With(method).Dec()
+	m.requests.With(method, code.String()).Inc()
+	m.duration.With(method).Observe(time.Since(req.start).Seconds())
+	if size >= 0 {
+		m.responseBytes.With(method).Observe(float64(size))
+	}
+}
+
+func readSize(c codec.Codec) int {
+	if sc, ok := c.(codec.SizeCodec); ok {
+		return sc.ReadSize()
+	}
+	return -1
+}
+
+func writeSize(c codec.Codec) int {
+	if sc, ok := c.(codec.SizeCodec); ok {
+		return sc.WriteSize()
+	}
+	return -1
+}
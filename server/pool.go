@@ -0,0 +1,134 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// ErrOverloaded is sent back instead of handling a call when the worker pool queue
+// or the concurrency cap of the service is full. The call was not executed.
+var ErrOverloaded error = serverError(status.ResourceExhausted, ReasonOverloaded, "rpc server: overloaded")
+
+// WithWorkerPool runs the methods on size workers instead of a goroutine each, a method
+// holds its worker until it returns, even once its call has been answered with a timeout.
+// At most queue calls wait for a worker, further calls are rejected with ErrOverloaded,
+// and the calls still queued at Shutdown or Close with ErrServerClosed.
+func WithWorkerPool(size, queue int) ServerOption {
+	return func(s *Server) {
+		if size <= 0 {
+			size = 1
+		}
+		if queue < 0 {
+			queue = 0
+		}
+		s.pool = newWorkerPool(size, queue)
+	}
+}
+
+// RegisterOption configures a service, see Server.Register.
+type RegisterOption func(svc *service) error
+
+// WithMaxConcurrency caps the calls of the service running or queued at the same time (bulkhead),
+// further calls are rejected with ErrOverloaded so that a slow service can't take the whole pool.
+// n <= 0 means no limit.
+func WithMaxConcurrency(n int) RegisterOption {
+	return func(svc *service) error {
+		if n > 0 {
+			svc.slots = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// poolTask runs a call with a nil error, or rejects it with err without running it.
+type poolTask func(err error)
+
+type workerPool struct {
+	tasks chan poolTask
+	quit  chan struct{}
+
+	mu      sync.RWMutex // guards stopped against submit
+	stopped bool
+}
+
+func newWorkerPool(size, queue int) *workerPool {
+	p := &workerPool{
+		tasks: make(chan poolTask, queue),
+		quit:  make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case task := <-p.tasks:
+			task(nil)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// submit queues task for a worker without blocking, it returns ErrOverloaded
+// if the queue is full and ErrServerClosed once the pool is stopped.
+func (p *workerPool) submit(task poolTask) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return ErrServerClosed
+	}
+	// queue 为 0 时只在有空闲 worker 时才能放入
+	select {
+	case p.tasks <- task:
+		return nil
+	default:
+		return ErrOverloaded
+	}
+}
+
+// stop stops the workers, the queued tasks are rejected with ErrServerClosed.
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.quit)
+	var queued []poolTask
+	for len(p.tasks) > 0 {
+		queued = append(queued, <-p.tasks)
+	}
+	p.mu.Unlock()
+	// 在锁外回包, 避免慢连接阻塞 submit
+	for _, task := range queued {
+		task(ErrServerClosed)
+	}
+}
+
+// dispatch runs task for a call of svc, on the worker pool if there is one.
+// task runs the method itself, so the slot of the bulkhead is held until the method returns.
+// If dispatch fails, task is not called.
+func (s *Server) dispatch(svc *service, task poolTask) error {
+	if !svc.acquire() {
+		return ErrOverloaded
+	}
+	run := func(err error) {
+		defer svc.release()
+		task(err)
+	}
+	if s.pool == nil {
+		go run(nil)
+		return nil
+	}
+	if err := s.pool.submit(run); err != nil {
+		svc.release()
+		return err
+	}
+	return nil
+}
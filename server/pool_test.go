@@ -0,0 +1,64 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_submit(t *testing.T) {
+	p := newWorkerPool(1, 1)
+	running, unblock, done := make(chan struct{}), make(chan struct{}), make(chan error, 2)
+	task := func(err error) {
+		if err == nil {
+			<-unblock
+		}
+		done <- err
+	}
+	if err := p.submit(func(err error) { close(running); task(err) }); err != nil {
+		t.Fatalf("expect the first task to be accepted, got %v", err)
+	}
+	<-running
+	if err := p.submit(task); err != nil {
+		t.Fatalf("expect the second task to be queued, got %v", err)
+	}
+	if err := p.submit(task); err != ErrOverloaded {
+		t.Fatalf("expect the third task to be rejected with ErrOverloaded, got %v", err)
+	}
+	// the queued task is rejected instead of running on its own goroutine
+	p.stop()
+	if err := <-done; err != ErrServerClosed {
+		t.Fatalf("expect the queued task to be rejected with ErrServerClosed, got %v", err)
+	}
+	if err := p.submit(task); err != ErrServerClosed {
+		t.Fatalf("expect ErrServerClosed once stopped, got %v", err)
+	}
+	close(unblock)
+	if err := <-done; err != nil {
+		t.Fatalf("expect the running task to finish, got %v", err)
+	}
+}
+
+func TestServer_dispatch(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	if err := s.Register(&foo, WithMaxConcurrency(1)); err != nil {
+		t.Fatal(err)
+	}
+	svc := s.loadVersions("Foo").get("")
+	unblock, done := make(chan struct{}), make(chan struct{})
+	if err := s.dispatch(svc, func(error) { <-unblock; close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.dispatch(svc, func(error) {}); err != ErrOverloaded {
+		t.Fatalf("expect ErrOverloaded when the bulkhead is full, got %v", err)
+	}
+	close(unblock)
+	<-done
+	// the slot is released right after the task returns
+	for i := 0; s.dispatch(svc, func(error) {}) != nil; i++ {
+		if i == 100 {
+			t.Fatal("expect the slot to be released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/metadata"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// ErrRateLimited matches every *RateLimitError with errors.Is.
+var ErrRateLimited = errors.New("rpc server: rate limit exceeded")
+
+// RateLimitError is returned for a call rejected by a rate limit, the call was not executed.
+type RateLimitError struct {
+	ServiceMethod string
+	Key           string        // metadata value the limit is keyed on, if any
+	RetryAfter    time.Duration // until a token is available
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s key=%q retry_after=%s", ErrRateLimited, e.ServiceMethod, e.Key, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Status maps e onto status.ResourceExhausted with a status.RetryInfo and a status.ErrorInfo.
+func (e *RateLimitError) Status() *status.Status {
+	return status.New(status.ResourceExhausted, e.Error()).WithDetails(
+		&status.RetryInfo{RetryAfter: e.RetryAfter},
+		&status.ErrorInfo{Domain: ErrorDomain, Reason: ReasonRateLimited, Metadata: map[string]string{"key": e.Key}},
+	)
+}
+
+// As lets errors.As find the status of e.
+func (e *RateLimitError) As(target interface{}) bool {
+	if st, ok := target.(**status.Status); ok {
+		*st = e.Status()
+		return true
+	}
+	return false
+}
+
+// ParseRateLimitError parses the message of a RateLimitError sent back to a client,
+// for servers that don't send status codes.
+func ParseRateLimitError(msg string) (*RateLimitError, bool) {
+	rest := strings.TrimPrefix(msg, ErrRateLimited.Error()+": ")
+	if len(rest) == len(msg) {
+		return nil, false
+	}
+	i := strings.LastIndex(rest, " key=")
+	if i < 0 {
+		return nil, false
+	}
+	e := &RateLimitError{ServiceMethod: rest[:i]}
+	rest = rest[i+len(" key="):]
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return nil, false
+	}
+	e.Key, _ = strconv.Unquote(quoted)
+	rest = strings.TrimPrefix(rest[len(quoted):], " retry_after=")
+	if e.RetryAfter, err = time.ParseDuration(rest); err != nil {
+		return nil, false
+	}
+	return e, true
+}
+
+// WithRateLimit limits the calls of the whole service to rate per second, with bursts of up to burst calls.
+func WithRateLimit(rate float64, burst int) RegisterOption {
+	return func(svc *service) error {
+		svc.limits = append(svc.limits, newRateLimit("", "", rate, burst))
+		return nil
+	}
+}
+
+// WithMethodRateLimit limits the calls of method to rate per second, with bursts of up to burst calls.
+func WithMethodRateLimit(method string, rate float64, burst int) RegisterOption {
+	return func(svc *service) error {
+		if svc.methods[method] == nil {
+			return fmt.Errorf("rpc: rate limit of unknown method %s.%s", svc.name, method)
+		}
+		svc.limits = append(svc.limits, newRateLimit(method, "", rate, burst))
+		return nil
+	}
+}
+
+// WithKeyedRateLimit limits the calls of the service carrying the same value of the
+// metadata key, e.g. a client id, to rate per second with bursts of up to burst calls.
+// Calls without the key share one limit.
+func WithKeyedRateLimit(key string, rate float64, burst int) RegisterOption {
+	return func(svc *service) error {
+		svc.limits = append(svc.limits, newRateLimit("", key, rate, burst))
+		return nil
+	}
+}
+
+const (
+	// maxBuckets bounds the buckets kept by a keyed limit, see take.
+	maxBuckets = 10000
+	// sweepInterval is how often a keyed limit drops the buckets idle long enough to be full.
+	sweepInterval = time.Minute
+)
+
+// rateLimit is a token bucket per metadata value of key, or a single one if key is empty.
+type rateLimit struct {
+	method string // empty for every method of the service
+	key    string
+	rate   float64
+	burst  float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimit(method, key string, rate float64, burst int) *rateLimit {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimit{
+		method:    method,
+		key:       key,
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// take takes a token for a call with metadata md,
+// if there is none it returns how long until one is available.
+func (l *rateLimit) take(md metadata.MD, now time.Time) (key string, wait time.Duration, ok bool) {
+	if l.key != "" {
+		key = md.Get(l.key)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.dropFullBuckets(now)
+		l.lastSweep = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= maxBuckets {
+			l.dropFullBuckets(now)
+		}
+		// 仍然没有空间时随机淘汰, 保证内存有界, 被淘汰的 key 重新获得 burst 个令牌
+		for evicted := range l.buckets {
+			if len(l.buckets) < maxBuckets {
+				break
+			}
+			delete(l.buckets, evicted)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	wait, ok = b.take(l.rate, l.burst, now)
+	return key, wait, ok
+}
+
+// refund gives back the token taken by take for key, when another limit rejected the call.
+func (l *rateLimit) refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b := l.buckets[key]; b != nil && b.tokens+1 <= l.burst {
+		b.tokens++
+	}
+}
+
+// dropFullBuckets must be called with l.mu held, a full bucket is the same as a new one.
+func (l *rateLimit) dropFullBuckets(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(l.rate, l.burst, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(rate, burst float64, now time.Time) float64 {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+	return b.tokens
+}
+
+func (b *tokenBucket) take(rate, burst float64, now time.Time) (time.Duration, bool) {
+	if b.refill(rate, burst, now) >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if rate <= 0 {
+		return time.Duration(1<<63 - 1), false
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+}
+
+// checkRateLimits takes a token from every limit of the call, or none if one of them rejects it.
+// It counts and returns a *RateLimitError for a rejected call.
+// s is nil for calls of the UnknownServiceHandler, which are not limited.
+func (s *service) checkRateLimits(m *methodType, serviceMethod string, md metadata.MD) error {
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	type taken struct {
+		l   *rateLimit
+		key string
+	}
+	var took []taken
+	for _, l := range s.limits {
+		if l.method != "" && l.method != m.method.Name {
+			continue
+		}
+		key, wait, ok := l.take(md, now)
+		if !ok {
+			// 被拒绝的请求不消耗其他限流的配额
+			for _, t := range took {
+				t.l.refund(t.key)
+			}
+			atomic.AddUint64(&m.NumRateLimited, 1)
+			return &RateLimitError{ServiceMethod: serviceMethod, Key: key, RetryAfter: wait}
+		}
+		took = append(took, taken{l, key})
+	}
+	return nil
+}
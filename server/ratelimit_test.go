@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/metadata"
+)
+
+func TestRateLimit_take(t *testing.T) {
+	now := time.Now()
+	l := newRateLimit("", "client-id", 10, 2)
+	a, b := metadata.Pairs("client-id", "a"), metadata.Pairs("client-id", "b")
+	for i := 0; i < 2; i++ {
+		if _, _, ok := l.take(a, now); !ok {
+			t.Fatalf("expect call %d to be within the burst", i)
+		}
+	}
+	key, wait, ok := l.take(a, now)
+	if ok || key != "a" || wait != time.Millisecond*100 {
+		t.Fatalf("expect a to wait 100ms, got ok %v key %q wait %s", ok, key, wait)
+	}
+	if _, _, ok := l.take(b, now); !ok {
+		t.Fatal("expect b to have its own bucket")
+	}
+	if _, _, ok := l.take(a, now.Add(time.Millisecond*100)); !ok {
+		t.Fatal("expect a token after 100ms")
+	}
+}
+
+func TestRateLimitError(t *testing.T) {
+	e := &RateLimitError{ServiceMethod: "Foo.Sum", Key: `client "1"`, RetryAfter: time.Millisecond * 250}
+	if !errors.Is(e, ErrRateLimited) {
+		t.Fatal("expect errors.Is ErrRateLimited")
+	}
+	got, ok := ParseRateLimitError(e.Error())
+	if !ok || *got != *e {
+		t.Fatalf("expect %+v, got %+v", e, got)
+	}
+	if _, ok := ParseRateLimitError("rpc server: overloaded"); ok {
+		t.Fatal("expect other errors not to parse")
+	}
+}
+
+func TestServer_RegisterRateLimit(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	if err := s.Register(&foo, WithMethodRateLimit("Missing", 1, 1)); err == nil {
+		t.Fatal("expect an error for an unknown method")
+	}
+	if err := s.Register(&foo, WithMethodRateLimit("Sum", 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRateLimit_evict(t *testing.T) {
+	now := time.Now()
+	l := newRateLimit("", "client-id", 1, 2)
+	for i := 0; i < maxBuckets+10; i++ {
+		l.take(metadata.Pairs("client-id", strconv.Itoa(i)), now)
+	}
+	if len(l.buckets) > maxBuckets {
+		t.Fatalf("expect at most %d buckets, got %d", maxBuckets, len(l.buckets))
+	}
+	// the idle buckets are full again by the next sweep
+	l.take(metadata.Pairs("client-id", "new"), now.Add(sweepInterval))
+	if len(l.buckets) != 1 {
+		t.Fatalf("expect the idle buckets to be dropped, got %d", len(l.buckets))
+	}
+}
+
+func TestService_checkRateLimits(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	if err := s.Register(&foo, WithRateLimit(0, 2), WithMethodRateLimit("Sum", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	svc, sum, _ := s.findService("Foo.Sum", "")
+	_, sumContext, _ := s.findService("Foo.SumContext", "")
+	if err := svc.checkRateLimits(sum, "Foo.Sum", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.checkRateLimits(sum, "Foo.Sum", nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expect the method limit to reject the call, got %v", err)
+	}
+	// the rejected call gave its token of the service limit back
+	if err := svc.checkRateLimits(sumContext, "Foo.SumContext", nil); err != nil {
+		t.Fatalf("expect a token of the service limit left, got %v", err)
+	}
+}
@@ -1,42 +1,90 @@
 package server
 
 import (
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/metrics"
+	"github.com/SnDragon/lrpc-go/status"
 	"io"
 	"net"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MagicNumber uint32 = 0x3bef5c
 
+// HandshakeTimeout bounds how long the server waits for a client's handshake.
+const HandshakeTimeout = time.Second * 10
+
 const (
 	Connected        = "200 Connected to LRPC"
 	DefaultRPCPath   = "/_lrpc_"
 	DefaultDebugPath = "/debug/lrpc"
+	// DefaultMetricsPath serves the metrics registry of the server in the Prometheus text format.
+	DefaultMetricsPath = "/debug/lrpc/metrics"
+)
+
+// ErrorDomain is the domain of the status.ErrorInfo marking the errors of the server itself,
+// e.g. ErrOverloaded, so that clients tell them from the errors returned by methods.
+const ErrorDomain = "lrpc"
+
+// Reasons of the status.ErrorInfo of the errors of the server, see ErrorDomain.
+const (
+	ReasonServerClosed = "SERVER_CLOSED" // ErrServerClosed
+	ReasonOverloaded   = "OVERLOADED"    // ErrOverloaded
+	ReasonInternal     = "INTERNAL"      // ErrInternal
+	ReasonRateLimited  = "RATE_LIMITED"  // RateLimitError, the metadata holds the key
 )
 
+// serverError returns a status of the server itself, see ErrorDomain.
+func serverError(code status.Code, reason, msg string) *status.Status {
+	return status.New(code, msg).WithDetails(&status.ErrorInfo{Domain: ErrorDomain, Reason: reason})
+}
+
 type Option struct {
-	MagicNumber    uint32          `json:"magic_number"`
-	CodecType      codec.CodecType `json:"codec_type"`
-	ConnectTimeout time.Duration   `json:"connect_timeout"`
-	HandleTimeout  time.Duration   `json:"handle_timeout"`
+	MagicNumber       uint32          `json:"magic_number"`
+	Version           uint32          `json:"version"` // handshake version, 0 for legacy clients
+	CodecType         codec.CodecType `json:"codec_type"`
+	SerializationType int             `json:"serialization_type"` // only used by codec.FormatCodec
+	CompressType      int             `json:"compress_type"`      // only used by codec.FormatCodec
+	ConnectTimeout    time.Duration   `json:"connect_timeout"`
+	HandleTimeout     time.Duration   `json:"handle_timeout"`
+	Features          uint32          `json:"features"` // optional features, negotiated in the handshake
 }
 
 type OptionFunc func(option *Option)
 
+// ApplyOption applies f to option, it makes f a client.DialOption.
+func (f OptionFunc) ApplyOption(option *Option) {
+	f(option)
+}
+
 func WithCodecType(codecType codec.CodecType) OptionFunc {
 	return func(option *Option) {
 		option.CodecType = codecType
 	}
 }
 
+func WithSerializationType(serializationType int) OptionFunc {
+	return func(option *Option) {
+		option.SerializationType = serializationType
+	}
+}
+
+func WithCompressType(compressType int) OptionFunc {
+	return func(option *Option) {
+		option.CompressType = compressType
+	}
+}
+
 func WithConnectTimeout(t time.Duration) OptionFunc {
 	return func(option *Option) {
 		option.ConnectTimeout = t
@@ -50,54 +98,240 @@ func WithHandleTimeout(t time.Duration) OptionFunc {
 }
 
 var DefaultOption = Option{
-	MagicNumber:    MagicNumber,
-	CodecType:      codec.CodecTypeGob,
-	ConnectTimeout: time.Second * 10,
+	MagicNumber:       MagicNumber,
+	Version:           ProtocolVersion,
+	CodecType:         codec.CodecTypeGob,
+	SerializationType: codec.SerializationTypeGob,
+	CompressType:      codec.CompressTypeNoop,
+	ConnectTimeout:    time.Second * 10,
+	Features:          FeatureCancel | FeatureGoAway,
 }
 
 type Server struct {
-	serviceMap sync.Map
+	admission    admission // first field, see admission
+	serviceMap   sync.Map
+	interceptors []UnaryServerInterceptor
+	interceptor  UnaryServerInterceptor // chain of interceptors
+	pool         *workerPool            // nil runs every call on its own goroutine
+	crashOnPanic bool
+	log          logger.Logger
+	metrics      *serverMetrics
+	traceSize    int // see WithTraces
+	redact       TraceRedactor
+	debugAdmin   bool // see WithDebugAdmin
+
+	unknownService UnknownServiceHandler
+
+	mu           sync.Mutex // guards the fields below, and changes to serviceMap
+	shuttingDown bool
+	listeners    map[net.Listener]struct{}
+	conns        map[*serverConn]struct{}
+	nextConnID   uint64
 }
 
-func NewServer() *Server {
-	return &Server{}
+// ServerOption configures a Server, see NewServer.
+type ServerOption func(s *Server)
+
+// WithCrashOnPanic lets a panicking method crash the process, after its stack is printed,
+// instead of answering the call with ErrInternal.
+func WithCrashOnPanic() ServerOption {
+	return func(s *Server) {
+		s.crashOnPanic = true
+	}
 }
 
-func (s *Server) Register(rcvr interface{}) error {
-	service := newService(rcvr)
-	if _, existed := s.serviceMap.LoadOrStore(service.name, service); existed {
-		return errors.New("rpc: service already define:" + service.name)
+// WithLogger sets the Logger of the server and of its codecs, logger.Default() if not set.
+// Every method gets a Logger with the seq, method and peer of the call from logger.FromContext.
+func WithLogger(l logger.Logger) ServerOption {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		log:       logger.Default(),
+		redact:    formatTraceValue,
+		metrics:   newServerMetrics(metrics.DefaultRegistry),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.interceptor = chainInterceptors(s.interceptors)
+	return s
+}
+
+// Register publishes the methods of rcvr as the service named after its type.
+func (s *Server) Register(rcvr interface{}, opts ...RegisterOption) error {
+	return s.register(newService(rcvr), opts)
+}
+
+// RegisterName is like Register but uses name for the service, which may be put in a
+// dotted namespace such as billing.v1.User, its methods are then called as billing.v1.User.Get.
+func (s *Server) RegisterName(name string, rcvr interface{}, opts ...RegisterOption) error {
+	if !validServiceName(name) {
+		return fmt.Errorf("rpc: invalid service name %q", name)
+	}
+	return s.register(newNamedService(name, rcvr), opts)
+}
+
+func (s *Server) register(service *service, opts []RegisterOption) error {
+	if err := s.prepare(service, opts); err != nil {
+		return err
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sv := s.loadVersions(service.name)
+	if sv == nil {
+		sv = &serviceVersions{}
+	} else if sv.versions[service.version] != nil {
+		return errors.New("rpc: service already define:" + service.name + versionSuffix(service.version))
+	}
+	s.serviceMap.Store(service.name, sv.with(service))
 	return nil
 }
 
-func (s *Server) findService(serviceMethod string) (svr *service, m *methodType, err error) {
-	idx := strings.Index(serviceMethod, ".")
+// prepare applies opts to service and checks it has methods.
+func (s *Server) prepare(service *service, opts []RegisterOption) error {
+	service.registered = time.Now()
+	if s.traceSize > 0 {
+		for _, m := range service.methods {
+			m.traces = newMethodTraces(s.traceSize)
+		}
+	}
+	for _, opt := range opts {
+		if err := opt(service); err != nil {
+			return err
+		}
+	}
+	if len(service.methods) == 0 {
+		return fmt.Errorf("rpc: service %s has no suitable methods, skipped: %v", service.name, service.skipped)
+	}
+	for name := range service.methods {
+		s.log.Debug("rpc server: method registered", logger.String("service", service.name), logger.String("method", name))
+	}
+	for name, reason := range service.skipped {
+		s.log.Debug("rpc server: method skipped", logger.String("service", service.name), logger.String("method", name), logger.String("reason", reason))
+	}
+	return nil
+}
+
+// Unregister removes the service name with all its versions. Calls already read
+// keep running, later calls get status.NotFound.
+func (s *Server) Unregister(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.serviceMap.LoadAndDelete(name); !ok {
+		return fmt.Errorf("rpc: service %s not registered", name)
+	}
+	return nil
+}
+
+// Replace swaps the receiver of the registered service name for rcvr, e.g. to reload an
+// implementation without dropping connections. Calls already read finish on the old receiver,
+// later calls go to rcvr. opts replace the ones given at registration, WithVersion selects
+// the version replaced.
+func (s *Server) Replace(name string, rcvr interface{}, opts ...RegisterOption) error {
+	if !validServiceName(name) {
+		return fmt.Errorf("rpc: invalid service name %q", name)
+	}
+	service := newNamedService(name, rcvr)
+	if err := s.prepare(service, opts); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sv := s.loadVersions(name)
+	if sv == nil || sv.versions[service.version] == nil {
+		return fmt.Errorf("rpc: service %s not registered", name+versionSuffix(service.version))
+	}
+	s.serviceMap.Store(name, sv.with(service))
+	return nil
+}
+
+// versionSuffix returns "@version" as services are named in discovery, "" if version is empty.
+func versionSuffix(version string) string {
+	if version == "" {
+		return ""
+	}
+	return "@" + version
+}
+
+// UnknownServiceHandler handles the calls of services or methods that are not registered,
+// e.g. to proxy them. body is the request body as sent by the client, serialized in its
+// format, and reply is sent back as is. ctx carries the metadata, peer and deadline as for a method.
+type UnknownServiceHandler func(ctx context.Context, serviceMethod string, body []byte) (reply []byte, err error)
+
+// SetUnknownServiceHandler sets the handler of unknown services and methods, it must be called
+// before serving. It requires a codec implementing codec.RawCodec, gob calls get status.NotFound.
+func (s *Server) SetUnknownServiceHandler(h UnknownServiceHandler) {
+	s.unknownService = h
+}
+
+// Services returns the names of the registered services, sorted, as given to discovery:
+// a service with versions is listed both as name and as name@version for each version.
+func (s *Server) Services() []string {
+	var names []string
+	s.serviceMap.Range(func(name, svi interface{}) bool {
+		names = append(names, name.(string))
+		for _, v := range svi.(*serviceVersions).sorted() {
+			if v != "" {
+				names = append(names, name.(string)+versionSuffix(v))
+			}
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// findService resolves `service.method`, the service name may contain dots.
+// version selects a version of the service, "" the default one.
+func (s *Server) findService(serviceMethod, version string) (svr *service, m *methodType, err error) {
+	idx := strings.LastIndex(serviceMethod, ".")
 	if idx <= 0 {
-		err = fmt.Errorf("server err: serviceMethod %s not found", serviceMethod)
+		err = status.Errorf(status.NotFound, "server err: serviceMethod %s not found", serviceMethod)
 		return
 	}
 	svrName, methodName := serviceMethod[0:idx], serviceMethod[idx+1:]
-	svrInter, ok := s.serviceMap.Load(svrName)
-	if !ok {
-		err = fmt.Errorf("server err: service %s not found", svrName)
+	sv := s.loadVersions(svrName)
+	if sv == nil {
+		err = status.Errorf(status.NotFound, "server err: service %s not found", svrName)
+		return
+	}
+	if svr = sv.get(version); svr == nil {
+		err = status.Errorf(status.NotFound, "server err: version %q of service %s not found", version, svrName)
 		return
 	}
-	svr = svrInter.(*service)
 	m = svr.methods[methodName]
 	if m == nil {
-		err = fmt.Errorf("server err: method %s not found", methodName)
+		err = status.Errorf(status.NotFound, "server err: method %s not found", methodName)
 		return
 	}
 	return
 }
 
+// Accept serves the connections of lis until it fails,
+// it returns ErrServerClosed after Shutdown or Close.
 func (s *Server) Accept(lis net.Listener) error {
+	if !s.trackListener(lis, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
+		if !s.admit(conn.RemoteAddr()) {
+			_ = conn.Close()
+			continue
+		}
 		go s.ServeConn(conn)
 	}
 }
@@ -106,56 +340,141 @@ func (s *Server) ServeConn(conn net.Conn) {
 	defer func() {
 		_ = conn.Close()
 	}()
-	var opt Option
-	//if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-	//	fmt.Println("ServeConn err:", err)
-	//	return
-	//}
-	var x uint32
-	binary.Read(conn, binary.BigEndian, &x)
-	fmt.Println("magicNumber:", x)
-	opt.MagicNumber = x
-	binary.Read(conn, binary.BigEndian, &x)
-	fmt.Println("codecType:", x)
-	opt.CodecType = codec.CodecType(x)
-	//opt.CodecType = codec.CodecTypeGob
-
+	log := s.log.With(logger.String("peer", conn.RemoteAddr().String()))
+	_ = conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	opt, versioned, err := readHandshake(conn)
+	if err != nil {
+		s.metrics.handshakeFailures.With("read").Inc()
+		log.Warn("rpc server: read handshake", logger.Err(err))
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
 	// 校验option magicNumber,codec等
-	if opt.MagicNumber != MagicNumber {
-		fmt.Println("err:", fmt.Errorf("invalid magicNumber: %v", opt.MagicNumber))
+	if err := checkOption(opt); err != nil {
+		s.metrics.handshakeFailures.With("option").Inc()
+		log.Warn("rpc server: reject handshake", logger.Err(err))
+		if versioned {
+			_ = writeHandshakeReply(conn, &HandshakeReply{Version: ProtocolVersion, Reason: err.Error()})
+		}
 		return
 	}
-	f := codec.CodecTypeMap[opt.CodecType]
-	if f == nil {
-		fmt.Println("err:", fmt.Errorf("invalid codecType: %v", opt.CodecType))
+	sc := newServerConn(conn, opt, s.admission.maxInflight, log)
+	if err := s.trackConn(sc); err != nil {
+		s.metrics.handshakeFailures.With("rejected").Inc()
+		log.Warn("rpc server: reject handshake", logger.Err(err))
+		if versioned {
+			_ = writeHandshakeReply(conn, &HandshakeReply{Version: ProtocolVersion, Reason: err.Error()})
+		}
 		return
 	}
-	s.serveCodec(f(conn), &opt)
+	defer s.untrackConn(sc)
+	if versioned {
+		reply := &HandshakeReply{Version: opt.Version, Accepted: true, Features: opt.Features}
+		if err := writeHandshakeReply(conn, reply); err != nil {
+			log.Warn("rpc server: write handshake reply", logger.Err(err))
+			return
+		}
+	}
+	s.serveCodec(sc)
 }
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (s *Server) serveCodec(c codec.Codec, opt *Option) {
+func (s *Server) serveCodec(sc *serverConn) {
+	c, mu := sc.codec, &sc.sending
 	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
+	// 连接断开后取消所有处理中的请求
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), peerKey{}, sc.peer))
+	defer cancel()
 	for {
-		req, err := s.readRequest(c)
+		req, err := s.readRequest(c, &sc.peer.Option)
 		if err != nil {
 			// EOF
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				fmt.Println("read done...")
+				sc.log.Debug("rpc server: connection closed")
+				break
+			}
+			if req == nil {
+				// 头部都读不出来,无法回包
+				sc.log.Warn("rpc server: read request header", logger.Err(err))
 				break
 			}
-			fmt.Println("readRequest err:", err)
+		}
+		if req.h.Type == codec.FrameTypeCancel {
+			// 取消帧不占用名额, 否则达到上限时无法取消处理中的请求
+			sc.calls.cancel(req.h.Seq)
+			continue
+		}
+		if req.h.Type != codec.FrameTypeCall {
+			// 客户端只发送调用和取消帧, 其它帧丢弃
+			sc.log.Warn("rpc server: unexpected frame", logger.Uint64("seq", req.h.Seq), logger.Any("type", req.h.Type))
+			continue
+		}
+		req.log = sc.requestLogger(req.h)
+		req.peer = sc.peer.Addr
+		s.metrics.begin(req, readSize(c))
+		if err != nil {
 			// 发送错误消息
-			req.h.Error = err.Error()
-			s.sendResponse(c, req.h, invalidRequest, mu)
-			return
+			req.log.Warn("rpc server: read request", logger.Err(err))
+			s.sendError(c, req, err, mu)
+			continue
+		}
+		// 分发前格式化参数, 超时后仍在运行的方法可能修改它
+		s.traceArgs(req)
+		if err := req.svr.checkRateLimits(req.mType, req.h.ServiceMethod, req.h.Metadata); err != nil {
+			s.sendError(c, req, err, mu)
+			continue
+		}
+		reqCtx, reqCancel := context.WithCancel(logger.NewContext(ctx, req.log))
+		if !sc.startCall(req, reqCancel) {
+			// 已发送 GOAWAY, 客户端应换一个连接重试
+			reqCancel()
+			s.sendError(c, req, ErrServerClosed, mu)
+			continue
 		}
 		wg.Add(1)
-		go s.handleRequest(c, req, wg, mu, opt.HandleTimeout)
+		finish := func() {
+			// 方法真正返回后才算结束, Shutdown 和 Connections 据此判断
+			sc.calls.done(req.h.Seq)
+			wg.Done()
+		}
+		task := func(err error) {
+			defer finish()
+			defer sc.release()
+			if err != nil {
+				// 未执行, 如排队时服务关闭
+				s.sendError(c, req, err, mu)
+				return
+			}
+			s.handleRequest(reqCtx, c, req, mu, sc.peer.Option.HandleTimeout)
+		}
+		run := func() {
+			if err := s.dispatch(req.svr, task); err != nil {
+				task(err)
+			}
+		}
+		switch {
+		case sc.tryAcquire():
+			run()
+		case sc.enqueue():
+			// 达到上限时在后台等待名额, 继续读取取消帧和 EOF
+			go func() {
+				if err := sc.waitSlot(reqCtx); err != nil {
+					// 等待时被取消或连接已断开, 不再回包
+					s.metrics.end(req, status.Canceled, -1)
+					s.trace(req, status.Canceled, nil)
+					finish()
+					return
+				}
+				run()
+			}()
+		default:
+			finish()
+			s.sendError(c, req, ErrOverloaded, mu)
+		}
 	}
+	cancel()
 	wg.Wait()
 }
 
@@ -164,18 +483,56 @@ type Request struct {
 	argv, replyv reflect.Value
 	svr          *service
 	mType        *methodType
+	raw          []byte        // body of a call for the UnknownServiceHandler, svr is nil
+	start        time.Time     // when the header was read
+	log          logger.Logger // with the seq, method and peer of the call
+	peer         net.Addr
+	traceArgs    string // the args formatted for the trace, see Server.traceArgs
+}
+
+// withDeadline applies the earlier of the caller's deadline and the handle timeout of the connection.
+func (r *Request) withDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if r.h.Timeout > 0 && (timeout == 0 || r.h.Timeout < timeout) {
+		timeout = r.h.Timeout
+	}
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, r.start.Add(timeout))
 }
 
-func (s *Server) readRequest(c codec.Codec) (r *Request, err error) {
+// readRequest returns a nil Request only if the header could not be read,
+// otherwise the error can be answered and the connection keeps serving.
+func (s *Server) readRequest(c codec.Codec, opt *Option) (r *Request, err error) {
 	h := &codec.Header{}
 	if err := c.ReadHeader(h); err != nil {
-		fmt.Println("ReadHeader err:", err)
 		return nil, err
 	}
 	r = &Request{
-		h: h,
+		h:     h,
+		start: time.Now(),
+	}
+	if h.Type != codec.FrameTypeCall {
+		return r, c.ReadBody(nil)
+	}
+	r.svr, r.mType, err = s.findService(h.ServiceMethod, h.Metadata[VersionKey])
+	if err != nil {
+		rc, ok := c.(codec.RawCodec)
+		if s.unknownService == nil || !ok {
+			_ = c.ReadBody(nil)
+			return r, err
+		}
+		// 交给 UnknownServiceHandler 处理, svr 为 nil
+		r.svr, r.mType = nil, nil
+		if r.raw, err = rc.ReadRawBody(); err != nil {
+			return r, status.Errorf(status.InvalidArgument, "rpc server: read body: %v", err)
+		}
+		return r, nil
+	}
+	if opt.CodecType == codec.CodecTypePB && !r.mType.protoMessage {
+		_ = c.ReadBody(nil)
+		return r, status.Errorf(status.InvalidArgument, "rpc server: %s does not take proto.Message args and reply", h.ServiceMethod)
 	}
-	r.svr, r.mType, err = s.findService(h.ServiceMethod)
 	r.argv = r.mType.newArgv()
 	r.replyv = r.mType.newReplyv()
 	argvi := r.argv.Interface()
@@ -183,56 +540,90 @@ func (s *Server) readRequest(c codec.Codec) (r *Request, err error) {
 		argvi = r.argv.Addr().Interface()
 	}
 	if err := c.ReadBody(argvi); err != nil {
-		fmt.Println("ReadBody err:", err)
-		return nil, err
+		return r, status.Errorf(status.InvalidArgument, "rpc server: read body: %v", err)
 	}
 	return r, nil
 }
 
-func (s *Server) handleRequest(c codec.Codec, req *Request, wg *sync.WaitGroup, mu *sync.Mutex, timeout time.Duration) {
-	defer wg.Done()
-	called, sent, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
-	defer close(finished)
-	go func() {
-		// 通过反射调用对应服务等逻辑处理方法
-		err := req.svr.call(req.mType, req.argv, req.replyv)
-		select {
-		case <-finished:
-			close(called)
-			close(sent)
-			return
-		case called <- struct{}{}:
-			if err != nil {
-				req.h.Error = err.Error()
-				s.sendResponse(c, req.h, invalidRequest, mu)
-				sent <- struct{}{}
+// handleRequest runs the method of req on the calling goroutine and answers it.
+// If the deadline expires first, the timeout error is sent right away and the reply
+// of the method is dropped once it returns.
+func (s *Server) handleRequest(ctx context.Context, c codec.Codec, req *Request, mu *sync.Mutex, timeout time.Duration) {
+	ctx, tr := newRequestContext(ctx, req)
+	ctx, cancel := req.withDeadline(ctx, timeout)
+	defer cancel()
+	if ctx.Err() == context.DeadlineExceeded {
+		// 调用方已经放弃了, 不再处理
+		s.sendError(c, req, status.New(status.DeadlineExceeded, "rpc server: request deadline exceeded before dispatch"), mu)
+		return
+	}
+	// 超时回包和方法返回后回包只能有一个
+	var answered int32
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			if !atomic.CompareAndSwapInt32(&answered, 0, 1) {
 				return
 			}
-			s.sendResponse(c, req.h, req.replyv.Interface(), mu)
-			sent <- struct{}{}
-		}
-
-	}()
-	if timeout == 0 {
-		<-called
-		<-sent
+			req.log.Warn("rpc server: handle timeout", logger.Any("timeout", deadline.Sub(req.start)))
+			s.sendError(c, req, status.Errorf(status.DeadlineExceeded, "rpc server: request handle timeout: expect within %s", deadline.Sub(req.start)), mu)
+		})
+		defer timer.Stop()
+	}
+	// 通过反射调用对应服务等逻辑处理方法
+	reply, err := s.invoke(ctx, req)
+	if !atomic.CompareAndSwapInt32(&answered, 0, 1) {
+		return
+	}
+	if ctx.Err() == context.Canceled {
+		// 连接已断开或调用方已取消, 不再回包
+		s.metrics.end(req, status.Canceled, -1)
+		s.trace(req, status.Canceled, nil)
 		return
 	}
-	select {
-	case <-time.After(timeout):
-		fmt.Println("rpc server: handle timeout")
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		s.sendResponse(c, req.h, invalidRequest, mu)
-	case <-called:
-		<-sent
+	// 响应头复用请求头, metadata 换成 trailer
+	req.h.Metadata = tr.get()
+	if err != nil {
+		setError(req.log, req.h, err)
+		s.sendResponse(c, req, invalidRequest, mu)
+		return
+	}
+	s.sendResponse(c, req, reply, mu)
+}
+
+// setError sets the error of the response header h, with the status code and details of err.
+func setError(log logger.Logger, h *codec.Header, err error) {
+	st := status.Convert(err)
+	code := st.Code
+	if code == status.OK {
+		// 返回了 OK 状态的 error, 仍按错误处理
+		code = status.Unknown
+	}
+	details, derr := status.EncodeDetails(st.Details)
+	if derr != nil {
+		log.Error("rpc server: encode status details", logger.Err(derr))
 	}
+	h.Error, h.Code, h.Details = st.Error(), uint32(code), details
+}
+
+// sendError answers req with err and no trailer.
+func (s *Server) sendError(c codec.Codec, req *Request, err error, mu *sync.Mutex) {
+	req.h.Metadata = nil
+	setError(req.log, req.h, err)
+	s.sendResponse(c, req, invalidRequest, mu)
 }
 
-func (s *Server) sendResponse(c codec.Codec, h *codec.Header, body interface{}, mu *sync.Mutex) error {
+// sendResponse answers req with the header req.h, every request read is answered
+// or counted as canceled once.
+func (s *Server) sendResponse(c codec.Codec, req *Request, body interface{}, mu *sync.Mutex) error {
+	code := status.Code(req.h.Code)
 	mu.Lock()
-	defer mu.Unlock()
-	if err := c.Write(h, body); err != nil {
-		fmt.Println("sendResponse err:", err)
+	err := c.Write(req.h, body)
+	s.metrics.end(req, code, writeSize(c))
+	mu.Unlock()
+	// 格式化回包可能很慢, 不占用写锁
+	s.trace(req, code, body)
+	if err != nil {
+		req.log.Warn("rpc server: send response", logger.Err(err))
 		return err
 	}
 	return nil
@@ -245,9 +636,15 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		_, _ = io.WriteString(w, "405 must CONNECT\n")
 		return
 	}
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil && !s.admit(addr) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, "403 forbidden\n")
+		return
+	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		fmt.Println("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		s.log.Error("rpc server: hijack", logger.String("peer", req.RemoteAddr), logger.Err(err))
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+Connected+"\n\n")
@@ -257,5 +654,6 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) HandleHTTP() {
 	http.Handle(DefaultRPCPath, s)
 	http.Handle(DefaultDebugPath, debugHTTP{s})
-	fmt.Println("rpc server debug path:", DefaultDebugPath)
+	http.Handle(DefaultMetricsPath, s.metrics.registry)
+	s.log.Info("rpc server: debug path", logger.String("path", DefaultDebugPath), logger.String("metrics", DefaultMetricsPath))
 }
@@ -1,17 +1,48 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"go/ast"
 	"reflect"
+	"runtime"
+	"strings"
 	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/SnDragon/lrpc-go/logger"
+	"github.com/SnDragon/lrpc-go/status"
 )
 
+// ErrInternal is returned for a call whose method panicked.
+var ErrInternal error = serverError(status.Internal, ReasonInternal, "rpc server: internal error")
+
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	NumCalls  uint64
+	method         reflect.Method
+	ArgType        reflect.Type
+	ReplyType      reflect.Type
+	withContext    bool // the first argument is a context.Context
+	returnsReply   bool // func (T) M(ctx, *Args) (*Reply, error)
+	protoMessage   bool // args and reply are both proto.Message, required by codec.CodecTypePB
+	NumCalls       uint64
+	NumErrors      uint64 // calls that returned an error or panicked
+	NumRateLimited uint64 // calls rejected by a rate limit
+	NumPanics      uint64 // calls that panicked, see ErrInternal
+	latency        *latencyStats
+	traces         *methodTraces // nil unless WithTraces
+}
+
+// signature returns the signature of the method without its receiver, for the debug page.
+func (m *methodType) signature() string {
+	name := m.method.Name
+	switch {
+	case m.returnsReply:
+		return fmt.Sprintf("%s(context.Context, %s) (%s, error)", name, m.ArgType, m.ReplyType)
+	case m.withContext:
+		return fmt.Sprintf("%s(context.Context, %s, %s) error", name, m.ArgType, m.ReplyType)
+	}
+	return fmt.Sprintf("%s(%s, %s) error", name, m.ArgType, m.ReplyType)
 }
 
 func (m *methodType) newArgv() reflect.Value {
@@ -40,59 +71,199 @@ type service struct {
 	typ     reflect.Type
 	rcvr    reflect.Value
 	methods map[string]*methodType
+	skipped map[string]string // exported methods not registered, method name -> reason
+	slots   chan struct{}     // bulkhead set by WithMaxConcurrency, nil means no limit
+	limits  []*rateLimit
+
+	version    string    // see WithVersion
+	isDefault  bool      // see AsDefaultVersion
+	registered time.Time // by Register or Replace
+}
+
+// acquire takes a slot of the bulkhead without blocking.
+// s is nil for calls of the UnknownServiceHandler.
+func (s *service) acquire() bool {
+	if s == nil || s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *service) release() {
+	if s != nil && s.slots != nil {
+		<-s.slots
+	}
 }
 
 func newService(rcvr interface{}) *service {
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) {
+		panic(fmt.Sprintf("rpc server: %s is not a valid service name", name))
+	}
+	return newNamedService(name, rcvr)
+}
+
+// newNamedService is newService with a name given by RegisterName, see validServiceName.
+func newNamedService(name string, rcvr interface{}) *service {
 	s := &service{
+		name: name,
 		rcvr: reflect.ValueOf(rcvr),
 		typ:  reflect.TypeOf(rcvr),
 	}
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
-	if !ast.IsExported(s.name) {
-		panic(fmt.Sprintf("rpc server: %s is not a valid service name", s.name))
-	}
 	s.RegisterMethods()
 	return s
 }
 
+// validServiceName reports whether name is made of dot separated identifiers, e.g. billing.v1.User.
+func validServiceName(name string) bool {
+	for _, part := range strings.Split(name, ".") {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// namespace returns the part of a service name before its last '.', e.g. billing.v1 for billing.v1.User.
+func namespace(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// RegisterMethods registers the methods of s.typ which meet the RPC conditions,
+// the others are recorded in s.skipped with the reason.
 func (s *service) RegisterMethods() {
 	s.methods = make(map[string]*methodType)
+	s.skipped = make(map[string]string)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		/**
-		RPC条件
-		1. the method’s type is exported. – 方法所属类型是导出的。
-		2. the method is exported. – 方法是导出的。
-		3. the method has two arguments, both exported (or builtin) types. – 两个入参，均为导出或内置类型。
-		4. the method’s second argument is a pointer. – 第二个入参必须是一个指针。
-		5. the method has return type error. – 返回值为 error 类型。
-		*/
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		mType, err := newMethodType(method)
+		if err != nil {
+			s.skipped[method.Name] = err.Error()
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
-		}
-		s.methods[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
-		fmt.Printf("rpc server : %s:%s registered\n", s.name, method.Name)
+		s.methods[method.Name] = mType
+	}
+}
+
+func newMethodType(method reflect.Method) (*methodType, error) {
+	mType := method.Type
+	/**
+	RPC条件
+	1. the method’s type is exported. – 方法所属类型是导出的。
+	2. the method is exported. – 方法是导出的。
+	3. the method has one of the signatures – 方法签名为以下之一:
+	   func (T) M(args, *reply) error
+	   func (T) M(ctx context.Context, args, *reply) error
+	   func (T) M(ctx context.Context, args) (*reply, error)
+	4. args and reply are exported (or builtin) types. – args 和 reply 均为导出或内置类型。
+	5. reply is a pointer. – reply 必须是一个指针。
+	6. proto.Message args are passed by pointer. – proto.Message 类型入参必须是指针。
+	*/
+	m := &methodType{method: method, latency: &latencyStats{}}
+	switch {
+	case mType.NumIn() == 3 && mType.NumOut() == 2 && mType.In(1) == typeOfContext:
+		m.withContext, m.returnsReply = true, true
+		m.ArgType, m.ReplyType = mType.In(2), mType.Out(0)
+	case mType.NumIn() == 4 && mType.NumOut() == 1 && mType.In(1) == typeOfContext:
+		m.withContext = true
+		m.ArgType, m.ReplyType = mType.In(2), mType.In(3)
+	case mType.NumIn() == 3 && mType.NumOut() == 1:
+		m.ArgType, m.ReplyType = mType.In(1), mType.In(2)
+	default:
+		return nil, fmt.Errorf("unsupported signature %s", mType)
+	}
+	if mType.Out(mType.NumOut()-1) != typeOfError {
+		return nil, fmt.Errorf("last return value is %s, not error", mType.Out(mType.NumOut()-1))
+	}
+	if !isExportedOrBuiltinType(m.ArgType) {
+		return nil, fmt.Errorf("args type %s is not exported", m.ArgType)
+	}
+	if !isExportedOrBuiltinType(m.ReplyType) {
+		return nil, fmt.Errorf("reply type %s is not exported", m.ReplyType)
+	}
+	if m.ReplyType.Kind() != reflect.Pointer {
+		return nil, fmt.Errorf("reply type %s is not a pointer", m.ReplyType)
+	}
+	if m.ArgType.Kind() != reflect.Pointer && isProtoMessage(reflect.PointerTo(m.ArgType)) {
+		return nil, fmt.Errorf("proto.Message args type %s is not a pointer", m.ArgType)
 	}
+	m.protoMessage = isProtoMessage(m.ArgType) && isProtoMessage(m.ReplyType)
+	return m, nil
 }
 
-func (s *service) call(m *methodType, args, reply reflect.Value) error {
+// call invokes m and returns the reply, which is the reply argument
+// unless the method returns its own.
+// A panic of the method is recovered by Server.invoke.
+func (s *service) call(ctx context.Context, m *methodType, args, reply reflect.Value) (replyv reflect.Value, err error) {
 	atomic.AddUint64(&m.NumCalls, 1)
+	start := time.Now()
+	panicked := true
+	defer func() {
+		m.latency.record(time.Since(start))
+		// panic 也计入错误
+		if err != nil || panicked {
+			atomic.AddUint64(&m.NumErrors, 1)
+		}
+	}()
+	replyv, err = s.callMethod(ctx, m, args, reply)
+	panicked = false
+	return replyv, err
+}
+
+func (s *service) callMethod(ctx context.Context, m *methodType, args, reply reflect.Value) (reflect.Value, error) {
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, args, reply})
+	if m.returnsReply {
+		returnValues := f.Call([]reflect.Value{s.rcvr, reflect.ValueOf(ctx), args})
+		if errInter := returnValues[1].Interface(); errInter != nil {
+			return reply, errInter.(error)
+		}
+		if !returnValues[0].IsNil() {
+			reply = returnValues[0]
+		}
+		return reply, nil
+	}
+	in := []reflect.Value{s.rcvr, args, reply}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), args, reply}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+		return reply, errInter.(error)
+	}
+	return reply, nil
+}
+
+// recoverCall must be deferred, it turns a panic of the call into ErrInternal,
+// or lets it crash the process if crash is set. numPanics may be nil.
+// The panic is logged with the Logger of ctx.
+func recoverCall(ctx context.Context, service, method string, numPanics *uint64, crash bool, err *error) {
+	// 一个请求 panic 不应该拖垮整个进程
+	r := recover()
+	if r == nil {
+		return
+	}
+	if numPanics != nil {
+		atomic.AddUint64(numPanics, 1)
+	}
+	buf := make([]byte, 64<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	// ctx 的 Logger 已带有 seq, method 和 peer
+	logger.FromContext(ctx).Error("rpc server: panic", logger.Any("panic", r), logger.String("stack", string(buf)))
+	if crash {
+		panic(r)
 	}
-	return nil
+	*err = fmt.Errorf("%w: panic in %s.%s", ErrInternal, service, method)
 }
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SnDragon/lrpc-go/codec"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) SumContext(ctx context.Context, args Args, reply *int) error {
+	return f.Sum(args, reply)
+}
+
+func (f Foo) SumReturn(ctx context.Context, args *Args) (*int, error) {
+	reply := args.Num1 + args.Num2
+	return &reply, nil
+}
+
+func (f Foo) Panic(args Args, reply *int) error { panic("boom") }
+
+func (f Foo) NoError(args Args, reply *int) {}
+
+func (f Foo) NotError(args Args, reply *int) int { return 0 }
+
+func (f Foo) NotPointer(args Args, reply int) error { return nil }
+
+func (f Foo) Unexported(args args, reply *int) error { return nil }
+
+type args struct{}
+
+func TestNewService(t *testing.T) {
+	var foo Foo
+	s := newService(&foo)
+	for _, name := range []string{"Sum", "SumContext", "SumReturn"} {
+		m := s.methods[name]
+		if m == nil {
+			t.Fatalf("expect %s to be registered, skipped: %v", name, s.skipped)
+		}
+		argv := m.newArgv()
+		argv = reflect.Indirect(argv)
+		argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
+		if m.ArgType.Kind() == reflect.Pointer {
+			argv = argv.Addr()
+		}
+		reply, err := s.call(context.Background(), m, argv, m.newReplyv())
+		if err != nil || *reply.Interface().(*int) != 4 {
+			t.Fatalf("%s: expect 4, got %v, err: %v", name, reply.Elem(), err)
+		}
+	}
+	skipped := map[string]string{
+		"NoError":    "unsupported signature",
+		"NotError":   "not error",
+		"NotPointer": "not a pointer",
+		"Unexported": "not exported",
+	}
+	for name, reason := range skipped {
+		if !strings.Contains(s.skipped[name], reason) {
+			t.Errorf("%s: expect skipped for %q, got %q", name, reason, s.skipped[name])
+		}
+	}
+}
+
+func TestServer_invokePanic(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	_ = s.Register(&foo)
+	svc, m, _ := s.findService("Foo.Panic", "")
+	newRequest := func() *Request {
+		return &Request{h: &codec.Header{ServiceMethod: "Foo.Panic"}, svr: svc, mType: m, argv: m.newArgv(), replyv: m.newReplyv()}
+	}
+	_, err := s.invoke(context.Background(), newRequest())
+	if !errors.Is(err, ErrInternal) || m.NumPanics != 1 || m.NumErrors != 1 {
+		t.Fatalf("expect ErrInternal and 1 panic, got %v, %d panics", err, m.NumPanics)
+	}
+
+	s.crashOnPanic = true
+	defer func() {
+		if r := recover(); r != "boom" {
+			t.Fatalf("expect the panic to propagate, got %v", r)
+		}
+	}()
+	_, _ = s.invoke(context.Background(), newRequest())
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	var foo Foo
+	s := NewServer()
+	for _, name := range []string{"billing.v1.Foo", "Foo", "shop.Foo"} {
+		if err := s.RegisterName(name, &foo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"", "billing..Foo", ".Foo", "billing.Foo.", "bill ing.Foo"} {
+		if err := s.RegisterName(name, &foo); err == nil {
+			t.Errorf("expect %q to be invalid", name)
+		}
+	}
+	svc, m, err := s.findService("billing.v1.Foo.Sum", "")
+	if err != nil || svc.name != "billing.v1.Foo" || m.method.Name != "Sum" {
+		t.Fatalf("expect billing.v1.Foo.Sum to be found, got %v", err)
+	}
+	if _, _, err := s.findService("billing.v2.Foo.Sum", ""); err == nil {
+		t.Fatal("expect billing.v2.Foo to be missing")
+	}
+	if got := s.Services(); !reflect.DeepEqual(got, []string{"Foo", "billing.v1.Foo", "shop.Foo"}) {
+		t.Fatalf("Services() got = %v", got)
+	}
+}
+
+type Bar struct{ n int }
+
+func (b *Bar) Get(args int, reply *int) error {
+	*reply = b.n
+	return nil
+}
+
+func TestServer_Replace(t *testing.T) {
+	s := NewServer()
+	if err := s.Replace("Bar", &Bar{n: 2}); err == nil {
+		t.Fatal("expect Replace of a missing service to fail")
+	}
+	if err := s.Register(&Bar{n: 1}); err != nil {
+		t.Fatal(err)
+	}
+	old, _, _ := s.findService("Bar.Get", "")
+	if err := s.Replace("Bar", &Bar{n: 2}); err != nil {
+		t.Fatal(err)
+	}
+	svc, m, err := s.findService("Bar.Get", "")
+	if err != nil || svc == old {
+		t.Fatalf("expect the new receiver, err: %v", err)
+	}
+	reply, _ := svc.call(context.Background(), m, reflect.ValueOf(0), m.newReplyv())
+	if *reply.Interface().(*int) != 2 {
+		t.Fatalf("expect 2 from the new receiver, got %v", reply.Elem())
+	}
+	// a call already holding the old service still runs on it
+	reply, _ = old.call(context.Background(), old.methods["Get"], reflect.ValueOf(0), m.newReplyv())
+	if *reply.Interface().(*int) != 1 {
+		t.Fatalf("expect 1 from the old receiver, got %v", reply.Elem())
+	}
+
+	if err := s.Unregister("Bar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.findService("Bar.Get", ""); err == nil {
+		t.Fatal("expect Bar to be unregistered")
+	}
+	if err := s.Unregister("Bar"); err == nil {
+		t.Fatal("expect a second Unregister to fail")
+	}
+	if err := s.Register(&Bar{n: 3}); err != nil {
+		t.Fatalf("expect Bar to be registered again, err: %v", err)
+	}
+}
+
+func TestServer_versions(t *testing.T) {
+	s := NewServer()
+	_ = s.Register(&Bar{n: 1}, WithVersion("v1"))
+	_ = s.Register(&Bar{n: 2}, WithVersion("v2"))
+	if err := s.Register(&Bar{n: 3}, WithVersion("v2")); err == nil {
+		t.Fatal("expect a duplicate version to fail")
+	}
+	if err := s.Register(&Bar{n: 3}, WithVersion("")); err == nil {
+		t.Fatal("expect an empty version to fail")
+	}
+	get := func(version string) int {
+		svc, m, err := s.findService("Bar.Get", version)
+		if err != nil {
+			return 0
+		}
+		reply, _ := svc.call(context.Background(), m, reflect.ValueOf(0), m.newReplyv())
+		return *reply.Interface().(*int)
+	}
+	if get("") != 1 || get("v1") != 1 || get("v2") != 2 || get("v3") != 0 {
+		t.Fatalf("expect v1 as default, got %d %d %d %d", get(""), get("v1"), get("v2"), get("v3"))
+	}
+	_ = s.Register(&Bar{n: 3}, WithVersion("v3"), AsDefaultVersion())
+	if get("") != 3 {
+		t.Fatalf("expect v3 as default, got %d", get(""))
+	}
+	if got := s.Services(); !reflect.DeepEqual(got, []string{"Bar", "Bar@v1", "Bar@v2", "Bar@v3"}) {
+		t.Fatalf("Services() got = %v", got)
+	}
+	if err := s.UnregisterVersion("Bar", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if get("") != 1 || get("v3") != 0 {
+		t.Fatalf("expect v1 as default again, got %d", get(""))
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// ErrServerClosed is returned by Accept after Shutdown or Close,
+// and to calls arriving on a connection after GOAWAY.
+var ErrServerClosed error = serverError(status.Unavailable, ReasonServerClosed, "rpc server: server closed")
+
+const shutdownPollInterval = time.Millisecond * 50
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// closeListenersLocked must be called with s.mu held.
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for lis := range s.listeners {
+		if cerr := lis.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.listeners, lis)
+	}
+	return err
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting connections,
+// sends GOAWAY on every connection so that clients stop sending new calls,
+// and closes each connection once the methods of its calls have returned,
+// including calls already answered with a timeout.
+// If ctx expires first, Shutdown returns ctx.Err() and the remaining
+// connections are left open, call Close to cut them off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	err := s.closeListenersLocked()
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.goAway()
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeDrainedConns() {
+			s.stopPool()
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeDrainedConns closes the connections with no call in flight,
+// and reports whether all connections are closed.
+func (s *Server) closeDrainedConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sc := range s.conns {
+		if sc.drained() {
+			_ = sc.conn.Close()
+			delete(s.conns, sc)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) stopPool() {
+	if s.pool != nil {
+		s.pool.stop()
+	}
+}
+
+// Close immediately closes all listeners and connections,
+// in-flight calls are cancelled. Use Shutdown to drain them first.
+func (s *Server) Close() error {
+	defer s.stopPool()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shuttingDown = true
+	err := s.closeListenersLocked()
+	for sc := range s.conns {
+		if cerr := sc.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.conns, sc)
+	}
+	return err
+}
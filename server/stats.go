@@ -0,0 +1,71 @@
+package server
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets 个桶, 每个桶的上界是前一个的 2^(1/4) 倍, 从 1µs 到约 18 分钟
+const latencyBuckets = 4 * 30
+
+// latencyStats keeps the durations of the calls of a method for the debug page.
+// Durations are counted in geometric buckets, so quantiles are approximate within 19%.
+type latencyStats struct {
+	buckets [latencyBuckets]uint64
+	count   uint64
+	sumNs   uint64
+}
+
+func latencyBucket(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us <= 1 {
+		return 0
+	}
+	i := int(math.Ceil(math.Log2(us) * 4))
+	if i >= latencyBuckets {
+		return latencyBuckets - 1
+	}
+	return i
+}
+
+// bucketBound is the upper bound of bucket i.
+func bucketBound(i int) time.Duration {
+	return time.Duration(math.Exp2(float64(i)/4) * float64(time.Microsecond))
+}
+
+func (l *latencyStats) record(d time.Duration) {
+	atomic.AddUint64(&l.buckets[latencyBucket(d)], 1)
+	atomic.AddUint64(&l.sumNs, uint64(d))
+	atomic.AddUint64(&l.count, 1)
+}
+
+// mean returns the average duration, 0 if there is no call.
+func (l *latencyStats) mean() time.Duration {
+	count := atomic.LoadUint64(&l.count)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&l.sumNs) / count)
+}
+
+// quantile returns the upper bound of the bucket holding the q quantile, 0 if there is no call.
+func (l *latencyStats) quantile(q float64) time.Duration {
+	var counts [latencyBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&l.buckets[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, n := range counts {
+		if seen += n; seen >= rank {
+			return bucketBound(i)
+		}
+	}
+	return bucketBound(latencyBuckets - 1)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+// TraceRedactor writes the args or reply of a traced call of method to w for the debug page,
+// e.g. hiding passwords or tokens. w drops what is written past TraceValueLimit bytes.
+type TraceRedactor func(w io.Writer, method string, v interface{})
+
+// TraceValueLimit is the size the args and reply of a trace are truncated to.
+// The args are formatted once read, before the method may change them.
+const TraceValueLimit = 256
+
+// WithTraces keeps the last n calls of every method, and the n slowest and n last failed ones,
+// browsable at DefaultDebugPath. The args and replies are kept formatted with %+v, see WithTraceRedactor.
+func WithTraces(n int) ServerOption {
+	return func(s *Server) {
+		s.traceSize = n
+	}
+}
+
+// WithTraceRedactor formats the args and replies of the traces with f instead of %+v.
+func WithTraceRedactor(f TraceRedactor) ServerOption {
+	return func(s *Server) {
+		s.redact = f
+	}
+}
+
+func formatTraceValue(w io.Writer, method string, v interface{}) {
+	_, _ = fmt.Fprintf(w, "%+v", v)
+}
+
+// traceBuffer keeps the first TraceValueLimit bytes written to it.
+type traceBuffer struct {
+	buf       []byte
+	truncated bool
+}
+
+func (b *traceBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if free := TraceValueLimit - len(b.buf); len(p) > free {
+		p, b.truncated = p[:free], true
+	}
+	b.buf = append(b.buf, p...)
+	// 超出部分丢弃, 不当作错误
+	return n, nil
+}
+
+// String returns what was kept, cut on a rune boundary and ending with ... if truncated.
+func (b *traceBuffer) String() string {
+	if !b.truncated {
+		return string(b.buf)
+	}
+	buf := b.buf
+	i := len(buf) - 1
+	for i > 0 && !utf8.RuneStart(buf[i]) {
+		i--
+	}
+	if i >= 0 && !utf8.FullRune(buf[i:]) {
+		buf = buf[:i]
+	}
+	return string(buf) + "..."
+}
+
+// trace is a call answered or canceled, see methodTraces.
+type trace struct {
+	Seq      uint64        `json:"seq"`
+	Peer     string        `json:"peer"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+	Code     string        `json:"code"`
+	Error    string        `json:"error,omitempty"`
+	Args     string        `json:"args"`
+	Reply    string        `json:"reply,omitempty"` // only for successful calls
+}
+
+// methodTraces keeps the recent, slowest and failed calls of a method.
+type methodTraces struct {
+	mu      sync.Mutex
+	size    int
+	recent  []*trace // ring buffer, next is the oldest once full
+	next    int
+	slowest []*trace // by duration, longest first
+	failed  []*trace // ring buffer
+	nextErr int
+}
+
+func newMethodTraces(size int) *methodTraces {
+	return &methodTraces{size: size}
+}
+
+// push writes t in the ring buffer ring at *next.
+func (mt *methodTraces) push(ring []*trace, next *int, t *trace) []*trace {
+	if len(ring) < mt.size {
+		return append(ring, t)
+	}
+	ring[*next] = t
+	*next = (*next + 1) % mt.size
+	return ring
+}
+
+func (mt *methodTraces) add(t *trace) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.recent = mt.push(mt.recent, &mt.next, t)
+	if t.Code != status.OK.String() {
+		mt.failed = mt.push(mt.failed, &mt.nextErr, t)
+	}
+	// 只保留最慢的 size 个
+	if len(mt.slowest) == mt.size && t.Duration <= mt.slowest[len(mt.slowest)-1].Duration {
+		return
+	}
+	i := sort.Search(len(mt.slowest), func(i int) bool { return mt.slowest[i].Duration < t.Duration })
+	mt.slowest = append(mt.slowest, nil)
+	copy(mt.slowest[i+1:], mt.slowest[i:])
+	mt.slowest[i] = t
+	if len(mt.slowest) > mt.size {
+		mt.slowest = mt.slowest[:mt.size]
+	}
+}
+
+// newestFirst copies the ring buffer ring whose oldest entry is at next.
+func newestFirst(ring []*trace, next int) []*trace {
+	traces := make([]*trace, 0, len(ring))
+	for i := 0; i < len(ring); i++ {
+		traces = append(traces, ring[(next-1-i+2*len(ring))%len(ring)])
+	}
+	return traces
+}
+
+// snapshot returns the recent and failed calls newest first, and the slowest calls.
+func (mt *methodTraces) snapshot() (recent, slowest, failed []*trace) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	recent = newestFirst(mt.recent, mt.next)
+	failed = newestFirst(mt.failed, mt.nextErr)
+	slowest = append(slowest, mt.slowest...)
+	return recent, slowest, failed
+}
+
+// formatTrace formats v, the args or reply of method, with the TraceRedactor.
+func (s *Server) formatTrace(method string, v interface{}) string {
+	var b traceBuffer
+	s.redact(&b, method, v)
+	return b.String()
+}
+
+// traceArgs formats the args of req if its method is traced, it must be called
+// before req is dispatched: a method still running after its timeout may change them.
+func (s *Server) traceArgs(req *Request) {
+	if req.mType == nil || req.mType.traces == nil || !req.argv.IsValid() {
+		return
+	}
+	req.traceArgs = s.formatTrace(req.h.ServiceMethod, req.argv.Interface())
+}
+
+// trace records the outcome of req, body is its reply if the call succeeded.
+// It must not be called with the write mutex of the connection held.
+func (s *Server) trace(req *Request, code status.Code, body interface{}) {
+	if req.mType == nil || req.mType.traces == nil {
+		return
+	}
+	t := &trace{
+		Seq:      req.h.Seq,
+		Start:    req.start,
+		Duration: time.Since(req.start),
+		Code:     code.String(),
+		Error:    req.h.Error,
+		Args:     req.traceArgs,
+	}
+	if t.Args == "" {
+		t.Args = "-" // not read
+	}
+	if req.peer != nil {
+		t.Peer = req.peer.String()
+	}
+	if code == status.OK && body != nil {
+		t.Reply = s.formatTrace(req.h.ServiceMethod, body)
+	}
+	req.mType.traces.add(t)
+}
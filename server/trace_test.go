@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/SnDragon/lrpc-go/codec"
+	"github.com/SnDragon/lrpc-go/logger"
+)
+
+func TestMethodTraces_add(t *testing.T) {
+	mt := newMethodTraces(2)
+	for i, d := range []time.Duration{3, 1, 5, 2} {
+		code := "OK"
+		if i%2 == 1 {
+			code = "Internal"
+		}
+		mt.add(&trace{Seq: uint64(i + 1), Duration: d, Code: code})
+	}
+	recent, slowest, failed := mt.snapshot()
+	seqs := func(traces []*trace) (seqs []uint64) {
+		for _, t := range traces {
+			seqs = append(seqs, t.Seq)
+		}
+		return seqs
+	}
+	for name, got := range map[string][]uint64{"recent": seqs(recent), "slowest": seqs(slowest), "failed": seqs(failed)} {
+		want := map[string][]uint64{"recent": {4, 3}, "slowest": {3, 1}, "failed": {4, 2}}[name]
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("%s: expect seqs %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestTraceBuffer(t *testing.T) {
+	var b traceBuffer
+	for i := 0; i < TraceValueLimit; i++ {
+		if n, err := b.Write([]byte("界")); n != len("界") || err != nil {
+			t.Fatalf("expect the write to succeed, got %d, %v", n, err)
+		}
+	}
+	if got := b.String(); len(got) > TraceValueLimit+3 || !strings.HasSuffix(got, "界...") || !utf8.ValidString(got) {
+		t.Errorf("unexpected truncation %q", got)
+	}
+	b = traceBuffer{}
+	_, _ = b.Write([]byte("short"))
+	if got := b.String(); got != "short" {
+		t.Errorf("expect short, got %q", got)
+	}
+}
+
+func TestServer_traces(t *testing.T) {
+	redact := func(w io.Writer, method string, v interface{}) {
+		if _, ok := v.(Args); ok {
+			_, _ = io.WriteString(w, "Args{Num1: ***}")
+			return
+		}
+		formatTraceValue(w, method, v)
+	}
+	s := NewServer(WithTraces(10), WithTraceRedactor(redact), WithLogger(logger.Nop()))
+	var foo Foo
+	_ = s.Register(&foo)
+	cli, srv := net.Pipe()
+	defer func() { _ = cli.Close() }()
+	go s.ServeConn(srv)
+	opt := DefaultOption
+	if err := WriteHandshake(cli, &opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHandshakeReply(cli); err != nil {
+		t.Fatal(err)
+	}
+	c := codec.NewCodecTypeGob(cli)
+	page := func(method string) (page debugTracePage) {
+		w := httptest.NewRecorder()
+		debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath+"?format=json&trace="+method, nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s: %v", w.Body.String(), err)
+		}
+		return page
+	}
+	go func() {
+		_ = c.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2})
+		_ = c.Write(&codec.Header{ServiceMethod: "Foo.Panic", Seq: 2}, Args{Num1: 1, Num2: 2})
+	}()
+	for i := 0; i < 2; i++ {
+		var h codec.Header
+		var reply int
+		if err := c.ReadHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		_ = c.ReadBody(&reply)
+	}
+	// the traces are recorded once the responses are written
+	for i := 0; len(page("Foo.Panic").Failed) == 0 || len(page("Foo.Sum").Recent) == 0; i++ {
+		if i == 100 {
+			t.Fatal("expect both calls to be traced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	sum := page("Foo.Sum")
+	if len(sum.Recent) != 1 || len(sum.Failed) != 0 {
+		t.Fatalf("expect one successful Foo.Sum, got %+v", sum)
+	}
+	if tr := sum.Recent[0]; tr.Seq != 1 || tr.Code != "OK" || tr.Args != "Args{Num1: ***}" || tr.Reply == "" || tr.Peer == "" {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+	panics := page("Foo.Panic")
+	if len(panics.Failed) != 1 || panics.Failed[0].Code != "Internal" || panics.Failed[0].Reply != "" {
+		t.Fatalf("expect one failed Foo.Panic, got %+v", panics)
+	}
+
+	w := httptest.NewRecorder()
+	debugHTTP{s}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath+"?trace=Foo.Sum", nil))
+	if body := w.Body.String(); !strings.Contains(body, "Slowest") || !strings.Contains(body, "Args{Num1: ***}") {
+		t.Fatalf("unexpected page: %s", body)
+	}
+	w = httptest.NewRecorder()
+	debugHTTP{NewServer()}.ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath+"?trace=Foo.Sum", nil))
+	if w.Code != 404 {
+		t.Fatalf("expect 404 for an unknown method, got %d", w.Code)
+	}
+}
+
+type Mutator struct{ done chan struct{} }
+
+// Change changes its args after the handle timeout of the test.
+func (m *Mutator) Change(args *Args, reply *int) error {
+	time.Sleep(time.Millisecond * 50)
+	args.Num1 = 42
+	close(m.done)
+	return nil
+}
+
+func TestServer_traceTimedOut(t *testing.T) {
+	s := NewServer(WithTraces(10), WithLogger(logger.Nop()))
+	m := &Mutator{done: make(chan struct{})}
+	_ = s.Register(m)
+	cli, srv := net.Pipe()
+	defer func() { _ = cli.Close() }()
+	go s.ServeConn(srv)
+	opt := DefaultOption
+	opt.HandleTimeout = time.Millisecond * 10
+	if err := WriteHandshake(cli, &opt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHandshakeReply(cli); err != nil {
+		t.Fatal(err)
+	}
+	c := codec.NewCodecTypeGob(cli)
+	go func() { _ = c.Write(&codec.Header{ServiceMethod: "Mutator.Change", Seq: 1}, &Args{Num1: 1}) }()
+	var h codec.Header
+	if err := c.ReadHeader(&h); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.ReadBody(nil)
+	<-m.done
+	// 参数在分发前已格式化, 不受方法之后的修改影响
+	_, mt, _ := s.findService("Mutator.Change", "")
+	_, _, failed := mt.traces.snapshot()
+	if len(failed) != 1 || failed[0].Code != "DeadlineExceeded" || !strings.Contains(failed[0].Args, "Num1:1") {
+		t.Fatalf("expect the timed out call with its args as read, got %+v", failed)
+	}
+}
@@ -1,10 +1,23 @@
 package server
 
 import (
+	"context"
 	"go/ast"
 	"reflect"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	typeOfProtoMessage = reflect.TypeOf((*proto.Message)(nil)).Elem()
+	typeOfContext      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError        = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
+
+func isProtoMessage(t reflect.Type) bool {
+	return t.Implements(typeOfProtoMessage)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VersionKey is the metadata key of a call selecting the version of the service, see WithVersion.
+const VersionKey = "lrpc-version"
+
+// WithVersion registers the receiver as version of the service, so that several
+// implementations of one service can run side by side. A call selects a version with
+// VersionKey in its metadata, calls without it go to the default version, the first one
+// registered unless AsDefaultVersion is given. Discovery sees the service as name@version.
+func WithVersion(version string) RegisterOption {
+	return func(svc *service) error {
+		if version == "" {
+			return fmt.Errorf("rpc: empty version of service %s", svc.name)
+		}
+		svc.version = version
+		return nil
+	}
+}
+
+// AsDefaultVersion makes the version registered the default one of the service.
+func AsDefaultVersion() RegisterOption {
+	return func(svc *service) error {
+		svc.isDefault = true
+		return nil
+	}
+}
+
+// serviceVersions are the services registered under one name, by version.
+// It's never modified once stored in Server.serviceMap, changes store a copy.
+type serviceVersions struct {
+	versions map[string]*service // "" for a service registered without WithVersion
+	def      string              // version of calls without VersionKey
+}
+
+// with returns a copy of sv with svc added or replacing its version.
+func (sv *serviceVersions) with(svc *service) *serviceVersions {
+	c := &serviceVersions{versions: make(map[string]*service, len(sv.versions)+1), def: sv.def}
+	for v, s := range sv.versions {
+		c.versions[v] = s
+	}
+	c.versions[svc.version] = svc
+	if len(sv.versions) == 0 || svc.isDefault {
+		c.def = svc.version
+	}
+	return c
+}
+
+// without returns a copy of sv without version, the lowest version left becomes the default if needed.
+func (sv *serviceVersions) without(version string) *serviceVersions {
+	c := &serviceVersions{versions: make(map[string]*service, len(sv.versions)), def: sv.def}
+	for v, s := range sv.versions {
+		if v != version {
+			c.versions[v] = s
+		}
+	}
+	if c.def == version {
+		c.def = ""
+		if left := c.sorted(); len(left) > 0 {
+			c.def = left[0]
+		}
+	}
+	return c
+}
+
+// get returns the service of version, or of the default version if version is empty.
+func (sv *serviceVersions) get(version string) *service {
+	if version == "" {
+		version = sv.def
+	}
+	return sv.versions[version]
+}
+
+func (sv *serviceVersions) sorted() []string {
+	versions := make([]string, 0, len(sv.versions))
+	for v := range sv.versions {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// loadVersions returns the versions registered under name, nil if there is none.
+func (s *Server) loadVersions(name string) *serviceVersions {
+	if sv, ok := s.serviceMap.Load(name); ok {
+		return sv.(*serviceVersions)
+	}
+	return nil
+}
+
+// UnregisterVersion removes version of the service name, see Unregister.
+// If it was the default version, the lowest version left becomes the default.
+func (s *Server) UnregisterVersion(name, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sv := s.loadVersions(name)
+	if sv == nil || sv.versions[version] == nil {
+		return fmt.Errorf("rpc: version %q of service %s not registered", version, name)
+	}
+	if len(sv.versions) == 1 {
+		s.serviceMap.Delete(name)
+		return nil
+	}
+	s.serviceMap.Store(name, sv.without(version))
+	return nil
+}
@@ -0,0 +1,126 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Detail is typed information attached to a Status, e.g. RetryInfo.
+// Details cross the wire as JSON tagged with DetailType, register a
+// custom detail with RegisterDetail so that clients decode it back.
+type Detail interface {
+	DetailType() string
+}
+
+// RetryInfo tells the client when it may retry a call.
+type RetryInfo struct {
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+func (*RetryInfo) DetailType() string { return "lrpc.RetryInfo" }
+
+// ErrorInfo describes the cause of an error in a machine-readable way.
+type ErrorInfo struct {
+	Reason   string            `json:"reason"` // e.g. "QUOTA_EXCEEDED"
+	Domain   string            `json:"domain,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (*ErrorInfo) DetailType() string { return "lrpc.ErrorInfo" }
+
+// RawDetail holds a detail whose type is not registered.
+type RawDetail struct {
+	Type  string
+	Value json.RawMessage
+}
+
+func (d *RawDetail) DetailType() string { return d.Type }
+
+var (
+	detailsMu   sync.RWMutex
+	detailTypes = map[string]reflect.Type{}
+)
+
+func init() {
+	RegisterDetail(&RetryInfo{})
+	RegisterDetail(&ErrorInfo{})
+}
+
+// RegisterDetail registers the type of d, a pointer to a struct, for decoding.
+func RegisterDetail(d Detail) {
+	t := reflect.TypeOf(d)
+	if t.Kind() != reflect.Pointer {
+		panic(fmt.Sprintf("status: detail %s is not a pointer", t))
+	}
+	detailsMu.Lock()
+	defer detailsMu.Unlock()
+	detailTypes[d.DetailType()] = t.Elem()
+}
+
+type wireDetail struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// EncodeDetails encodes details for codec.Header.Details, "" if there is none.
+func EncodeDetails(details []Detail) (string, error) {
+	if len(details) == 0 {
+		return "", nil
+	}
+	wire := make([]wireDetail, 0, len(details))
+	for _, d := range details {
+		if raw, ok := d.(*RawDetail); ok {
+			wire = append(wire, wireDetail{Type: raw.Type, Value: raw.Value})
+			continue
+		}
+		value, err := json.Marshal(d)
+		if err != nil {
+			return "", fmt.Errorf("status: encode detail %s: %w", d.DetailType(), err)
+		}
+		wire = append(wire, wireDetail{Type: d.DetailType(), Value: value})
+	}
+	data, err := json.Marshal(wire)
+	return string(data), err
+}
+
+// DecodeDetails decodes the details encoded by EncodeDetails,
+// the ones of unregistered types are returned as *RawDetail.
+func DecodeDetails(data string) ([]Detail, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var wire []wireDetail
+	if err := json.Unmarshal([]byte(data), &wire); err != nil {
+		return nil, fmt.Errorf("status: decode details: %w", err)
+	}
+	details := make([]Detail, 0, len(wire))
+	detailsMu.RLock()
+	defer detailsMu.RUnlock()
+	for _, w := range wire {
+		t, ok := detailTypes[w.Type]
+		if !ok {
+			details = append(details, &RawDetail{Type: w.Type, Value: w.Value})
+			continue
+		}
+		v := reflect.New(t)
+		if err := json.Unmarshal(w.Value, v.Interface()); err != nil {
+			return nil, fmt.Errorf("status: decode detail %s: %w", w.Type, err)
+		}
+		details = append(details, v.Interface().(Detail))
+	}
+	return details, nil
+}
+
+// RetryAfter returns the RetryInfo of err, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	s, _ := FromError(err)
+	for _, d := range s.Details {
+		if ri, ok := d.(*RetryInfo); ok {
+			return ri.RetryAfter, true
+		}
+	}
+	return 0, false
+}
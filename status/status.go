@@ -0,0 +1,128 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Code classifies an error sent back to a client.
+type Code uint32
+
+const (
+	OK                Code = 0
+	Canceled          Code = 1  // the caller cancelled the call
+	Unknown           Code = 2  // an error without a code, e.g. errors.New in a method
+	InvalidArgument   Code = 3  // the request can't be decoded or is not accepted by the method
+	DeadlineExceeded  Code = 4  // the deadline of the call or the handle timeout expired
+	NotFound          Code = 5  // unknown service or method
+	ResourceExhausted Code = 8  // overloaded or rate limited, see RetryInfo
+	Internal          Code = 13 // the method panicked
+	Unavailable       Code = 14 // the server or connection is shutting down, the call may be retried elsewhere
+
+	// FirstAppCode is the first code left to applications, e.g.
+	//
+	//	const CodeOutOfStock = status.FirstAppCode + iota
+	FirstAppCode Code = 1000
+)
+
+var codeNames = map[Code]string{
+	OK:                "OK",
+	Canceled:          "Canceled",
+	Unknown:           "Unknown",
+	InvalidArgument:   "InvalidArgument",
+	DeadlineExceeded:  "DeadlineExceeded",
+	NotFound:          "NotFound",
+	ResourceExhausted: "ResourceExhausted",
+	Internal:          "Internal",
+	Unavailable:       "Unavailable",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	if c >= FirstAppCode {
+		return fmt.Sprintf("AppCode(%d)", uint32(c))
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Status is an error with a code and typed details. Methods may return it,
+// clients get it back with errors.As:
+//
+//	var st *status.Status
+//	if errors.As(err, &st) && st.Code == status.NotFound { ... }
+type Status struct {
+	Code    Code
+	Message string
+	Details []Detail
+}
+
+// New returns a Status, it's an error unless code is OK.
+func New(code Code, msg string) *Status {
+	return &Status{Code: code, Message: msg}
+}
+
+// Errorf returns a Status error with a formatted message.
+func Errorf(code Code, format string, a ...interface{}) error {
+	return New(code, fmt.Sprintf(format, a...))
+}
+
+func (s *Status) Error() string {
+	if s.Message == "" {
+		return s.Code.String()
+	}
+	return s.Message
+}
+
+// WithDetails returns a copy of s with details appended.
+func (s *Status) WithDetails(details ...Detail) *Status {
+	c := *s
+	c.Details = append(append([]Detail(nil), s.Details...), details...)
+	return &c
+}
+
+// statusError is implemented by errors that are not a *Status but map onto one.
+type statusError interface {
+	Status() *Status
+}
+
+// FromError returns the Status of err, which may wrap a *Status or an error
+// implementing Status() *Status. The message of a wrapped status is err.Error().
+// ok is false, with a Unknown status, if err carries no status.
+func FromError(err error) (s *Status, ok bool) {
+	if err == nil {
+		return New(OK, ""), true
+	}
+	if errors.As(err, &s) {
+		if error(s) != err {
+			s = &Status{Code: s.Code, Message: err.Error(), Details: s.Details}
+		}
+		return s, true
+	}
+	var se statusError
+	if errors.As(err, &se) {
+		s = se.Status()
+		return &Status{Code: s.Code, Message: err.Error(), Details: s.Details}, true
+	}
+	return New(Unknown, err.Error()), false
+}
+
+// Convert is FromError, with context errors mapped onto Canceled and DeadlineExceeded.
+func Convert(err error) *Status {
+	s, ok := FromError(err)
+	switch {
+	case ok:
+	case errors.Is(err, context.DeadlineExceeded):
+		s.Code = DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		s.Code = Canceled
+	}
+	return s
+}
+
+// CodeOf returns the code of err, OK if err is nil.
+func CodeOf(err error) Code {
+	return Convert(err).Code
+}
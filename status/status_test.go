@@ -0,0 +1,69 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFromError(t *testing.T) {
+	notFound := New(NotFound, "no such method")
+	cases := []struct {
+		name string
+		err  error
+		code Code
+		msg  string
+		ok   bool
+	}{
+		{"nil", nil, OK, "", true},
+		{"status", notFound, NotFound, "no such method", true},
+		{"wrapped", fmt.Errorf("call Foo.Bar: %w", notFound), NotFound, "call Foo.Bar: no such method", true},
+		{"plain", errors.New("boom"), Unknown, "boom", false},
+	}
+	for _, c := range cases {
+		s, ok := FromError(c.err)
+		if ok != c.ok || s.Code != c.code || s.Message != c.msg {
+			t.Errorf("%s: got %v %q %v, want %v %q %v", c.name, s.Code, s.Message, ok, c.code, c.msg, c.ok)
+		}
+	}
+	if CodeOf(fmt.Errorf("call: %w", context.DeadlineExceeded)) != DeadlineExceeded {
+		t.Error("expect context.DeadlineExceeded to map onto DeadlineExceeded")
+	}
+	if CodeOf(context.Canceled) != Canceled {
+		t.Error("expect context.Canceled to map onto Canceled")
+	}
+}
+
+type quota struct {
+	Limit int `json:"limit"`
+}
+
+func (*quota) DetailType() string { return "test.Quota" }
+
+func TestDetails(t *testing.T) {
+	RegisterDetail(&quota{})
+	details := []Detail{
+		&RetryInfo{RetryAfter: time.Second},
+		&ErrorInfo{Reason: "QUOTA_EXCEEDED", Metadata: map[string]string{"tenant": "a"}},
+		&quota{Limit: 3},
+		&RawDetail{Type: "test.Unknown", Value: []byte(`{"x":1}`)},
+	}
+	data, err := EncodeDetails(details)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeDetails(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, details) {
+		t.Fatalf("DecodeDetails() got = %+v, want %+v", got, details)
+	}
+	after, ok := RetryAfter(New(ResourceExhausted, "slow down").WithDetails(details...))
+	if !ok || after != time.Second {
+		t.Fatalf("RetryAfter() got = %s %v", after, ok)
+	}
+}
@@ -13,3 +13,12 @@ type Discovery interface {
 	Get(mode SelectMode) (string, error)
 	GetAll() ([]string, error)
 }
+
+// ServiceDiscovery is implemented by discoveries that know the services of each server,
+// XClient then only picks among the servers of the service called.
+// A service is the part of `service.method` before the last '.', e.g. billing.v1.User.
+type ServiceDiscovery interface {
+	Discovery
+	GetForService(service string, mode SelectMode) (string, error)
+	GetAllForService(service string) ([]string, error)
+}
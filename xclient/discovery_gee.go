@@ -1,10 +1,11 @@
 package xclient
 
 import (
-	"log"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/SnDragon/lrpc-go/logger"
 )
 
 type RegistryDiscovery struct {
@@ -12,6 +13,7 @@ type RegistryDiscovery struct {
 	registry   string
 	timeout    time.Duration
 	lastUpdate time.Time
+	log        logger.Logger
 }
 
 const DefaultUpdateTimeout = time.Second * 10
@@ -24,9 +26,15 @@ func NewRegistryDiscovery(registryAddr string, timeout time.Duration) *RegistryD
 		MultiServerDiscovery: NewMultiServerDiscovery(make([]string, 0)),
 		registry:             registryAddr,
 		timeout:              timeout,
+		log:                  logger.Default(),
 	}
 }
 
+// SetLogger sets the Logger of d, logger.Default() if not set. It must be called before d is used.
+func (d *RegistryDiscovery) SetLogger(l logger.Logger) {
+	d.log = l
+}
+
 func (d *RegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -41,12 +49,13 @@ func (d *RegistryDiscovery) Refresh() error {
 	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
 		return nil
 	}
-	log.Println("rpc registry: refresh servers from registry", d.registry)
+	d.log.Debug("rpc registry: refresh servers", logger.String("registry", d.registry))
 	resp, err := http.Get(d.registry)
 	if err != nil {
-		log.Println("rpc registry refresh err:", err)
+		d.log.Warn("rpc registry: refresh servers", logger.String("registry", d.registry), logger.Err(err))
 		return err
 	}
+	defer func() { _ = resp.Body.Close() }()
 	servers := strings.Split(resp.Header.Get("X-LRPC-Servers"), ",")
 	d.servers = make([]string, 0, len(servers))
 	for _, s := range servers {
@@ -54,6 +63,13 @@ func (d *RegistryDiscovery) Refresh() error {
 			d.servers = append(d.servers, strings.TrimSpace(s))
 		}
 	}
+	// 每行 addr service1,service2, 没有上报服务的服务端不出现
+	d.services = make(map[string][]string)
+	for _, line := range resp.Header.Values("X-LRPC-Server-Services") {
+		if addr, services, ok := strings.Cut(line, " "); ok {
+			d.services[addr] = strings.Split(services, ",")
+		}
+	}
 	d.lastUpdate = time.Now()
 	return nil
 }
@@ -71,3 +87,17 @@ func (d *RegistryDiscovery) GetAll() ([]string, error) {
 	}
 	return d.MultiServerDiscovery.GetAll()
 }
+
+func (d *RegistryDiscovery) GetForService(service string, mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServerDiscovery.GetForService(service, mode)
+}
+
+func (d *RegistryDiscovery) GetAllForService(service string) ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServerDiscovery.GetAllForService(service)
+}
@@ -2,6 +2,7 @@ package xclient
 
 import (
 	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"sync"
@@ -13,9 +14,10 @@ func init() {
 }
 
 type MultiServerDiscovery struct {
-	mu      sync.RWMutex
-	servers []string
-	index   int
+	mu       sync.RWMutex
+	servers  []string
+	services map[string][]string // server -> its services, servers not in it may have any service
+	index    int
 }
 
 func NewMultiServerDiscovery(servers []string) *MultiServerDiscovery {
@@ -37,18 +39,35 @@ func (m *MultiServerDiscovery) Update(services []string) error {
 	return nil
 }
 
+// UpdateServices sets the services of each server, for GetForService.
+func (m *MultiServerDiscovery) UpdateServices(services map[string][]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services = services
+	return nil
+}
+
 func (m *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
+	return m.GetForService("", mode)
+}
+
+// GetForService picks a server of service, "" for any server.
+func (m *MultiServerDiscovery) GetForService(service string, mode SelectMode) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	n := len(m.servers)
+	servers := m.serversFor(service)
+	n := len(servers)
 	if n == 0 {
+		if service != "" {
+			return "", fmt.Errorf("rpc discovery: no available servers for service %s", service)
+		}
 		return "", errors.New("rpc discovery: no available servers")
 	}
 	switch mode {
 	case RandomSelect:
-		return m.servers[rand.Intn(n)], nil
+		return servers[rand.Intn(n)], nil
 	case RoundRobinSelect:
-		s := m.servers[m.index%n]
+		s := servers[m.index%n]
 		m.index = (m.index + 1) % n
 		return s, nil
 	default:
@@ -57,12 +76,44 @@ func (m *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
 }
 
 func (m *MultiServerDiscovery) GetAll() ([]string, error) {
+	return m.GetAllForService("")
+}
+
+// GetAllForService returns the servers of service, "" for all servers.
+// It fails if no server serves service.
+func (m *MultiServerDiscovery) GetAllForService(service string) ([]string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	// return a copy of d.servers
-	servers := make([]string, len(m.servers), len(m.servers))
-	copy(servers, m.servers)
+	found := m.serversFor(service)
+	if len(found) == 0 && service != "" {
+		return nil, fmt.Errorf("rpc discovery: no available servers for service %s", service)
+	}
+	servers := make([]string, len(found))
+	copy(servers, found)
 	return servers, nil
 }
 
-var _ Discovery = (*MultiServerDiscovery)(nil)
+// serversFor must be called with m.mu held, it may return m.servers itself.
+func (m *MultiServerDiscovery) serversFor(service string) []string {
+	if service == "" || len(m.services) == 0 {
+		return m.servers
+	}
+	var servers []string
+	for _, s := range m.servers {
+		services, ok := m.services[s]
+		if !ok {
+			servers = append(servers, s)
+			continue
+		}
+		for _, name := range services {
+			if name == service {
+				servers = append(servers, s)
+				break
+			}
+		}
+	}
+	return servers
+}
+
+var _ ServiceDiscovery = (*MultiServerDiscovery)(nil)
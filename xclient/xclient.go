@@ -2,24 +2,34 @@ package xclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/SnDragon/lrpc-go/client"
+	"github.com/SnDragon/lrpc-go/metadata"
 	"github.com/SnDragon/lrpc-go/server"
 	"io"
 	"reflect"
+	"strings"
 	"sync"
 )
 
 type XClient struct {
 	d       Discovery
 	mode    SelectMode
-	opts    []server.OptionFunc
+	opts    []client.DialOption
 	mu      sync.Mutex
 	clients map[string]*client.Client
+	// 收到 GOAWAY 时还有调用在处理的连接, 空闲后或 Close 时关闭
+	draining []*client.Client
 }
 
 var _ io.Closer = (*XClient)(nil)
 
-func NewXClient(d Discovery, mode SelectMode, opts ...server.OptionFunc) *XClient {
+// NewXClient returns a client balancing calls over the servers of d.
+// opts apply to every connection, so interceptors set by client.WithInterceptors
+// see the address chosen for each call in client.CallInfo.Addr,
+// and client.WithLogger sets the Logger of every client.
+func NewXClient(d Discovery, mode SelectMode, opts ...client.DialOption) *XClient {
 	return &XClient{
 		d:       d,
 		mode:    mode,
@@ -34,15 +44,39 @@ func (xc *XClient) Close() error {
 		_ = c.Close()
 		delete(xc.clients, key)
 	}
+	for _, c := range xc.draining {
+		_ = c.Close()
+	}
+	xc.draining = nil
 	return nil
 }
 
+// closeIdle closes the draining clients whose calls have all returned, it must be called with xc.mu held.
+func (xc *XClient) closeIdle() {
+	draining := xc.draining[:0]
+	for _, c := range xc.draining {
+		if c.Pending() == 0 {
+			_ = c.Close()
+			continue
+		}
+		draining = append(draining, c)
+	}
+	xc.draining = draining
+}
+
 func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
+	xc.closeIdle()
 	c, ok := xc.clients[rpcAddr]
 	if ok && !c.IsAvailable() {
-		_ = c.Close()
+		if c.GoingAway() && c.Pending() > 0 {
+			// 收到 GOAWAY 的连接上还有调用在处理, 处理完再关闭;
+			// 服务端 Shutdown 超时后不会关闭它
+			xc.draining = append(xc.draining, c)
+		} else {
+			_ = c.Close()
+		}
 		delete(xc.clients, rpcAddr)
 		c = nil
 	}
@@ -57,32 +91,93 @@ func (xc *XClient) dial(rpcAddr string) (*client.Client, error) {
 	return c, nil
 }
 
+// errNotSent wraps dial errors, the call can safely move to another server.
+var errNotSent = errors.New("rpc xclient: call not sent")
+
 func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceName string, argv, reply interface{}) error {
 	c, err := xc.dial(rpcAddr)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", errNotSent, err)
 	}
 	return c.Call(ctx, serviceName, argv, reply)
 }
 
+// Call invokes the named function on a server picked by the select mode.
+// When the call could not be sent, because the server is going away or
+// can't be dialed, it moves on to the servers not tried yet.
 func (xc *XClient) Call(ctx context.Context, serviceName string, argv, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+	rpcAddr, err := xc.get(ctx, serviceName)
 	if err != nil {
 		return err
 	}
-	return xc.call(rpcAddr, ctx, serviceName, argv, reply)
+	tried := make(map[string]bool)
+	for {
+		err = xc.call(rpcAddr, ctx, serviceName, argv, reply)
+		if err == nil || !errors.Is(err, errNotSent) && !errors.Is(err, client.ErrShutDown) {
+			return err
+		}
+		tried[rpcAddr] = true
+		if rpcAddr = xc.untried(ctx, serviceName, tried); rpcAddr == "" {
+			return err
+		}
+	}
+}
+
+// untried returns a server of serviceMethod not in tried, or "" if there is none.
+func (xc *XClient) untried(ctx context.Context, serviceMethod string, tried map[string]bool) string {
+	servers, err := xc.getAll(ctx, serviceMethod)
+	if err != nil {
+		return ""
+	}
+	for _, s := range servers {
+		if !tried[s] {
+			return s
+		}
+	}
+	return ""
 }
 
+// get picks a server for serviceMethod, among the servers of its service if the discovery knows them.
+func (xc *XClient) get(ctx context.Context, serviceMethod string) (string, error) {
+	if sd, ok := xc.d.(ServiceDiscovery); ok {
+		return sd.GetForService(serviceOf(ctx, serviceMethod), xc.mode)
+	}
+	return xc.d.Get(xc.mode)
+}
+
+func (xc *XClient) getAll(ctx context.Context, serviceMethod string) ([]string, error) {
+	if sd, ok := xc.d.(ServiceDiscovery); ok {
+		return sd.GetAllForService(serviceOf(ctx, serviceMethod))
+	}
+	return xc.d.GetAll()
+}
+
+// serviceOf returns the service of `service.method`, which may be in a dotted namespace,
+// as service@version if the call selects a version with server.VersionKey.
+func serviceOf(ctx context.Context, serviceMethod string) string {
+	service := serviceMethod
+	if i := strings.LastIndex(serviceMethod, "."); i >= 0 {
+		service = serviceMethod[:i]
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if version := md.Get(server.VersionKey); version != "" {
+		service += "@" + version
+	}
+	return service
+}
+
+// Broadcast calls serviceMethod on every server of its service.
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, argv, reply interface{}) error {
-	servers, err := xc.d.GetAll()
+	servers, err := xc.getAll(ctx, serviceMethod)
 	if err != nil {
-		return nil
+		return err
 	}
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var e error
 	replyDone := reply == nil
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -0,0 +1,162 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SnDragon/lrpc-go/metadata"
+	"github.com/SnDragon/lrpc-go/server"
+	"github.com/SnDragon/lrpc-go/status"
+)
+
+type User struct{ team string }
+
+func (u *User) Team(argv int, reply *string) error {
+	*reply = u.team
+	return nil
+}
+
+type Flaky struct{ calls int32 }
+
+// Down fails with status.Unavailable after running, as a method whose database is down.
+func (f *Flaky) Down(argv int, reply *int) error {
+	atomic.AddInt32(&f.calls, 1)
+	return status.New(status.Unavailable, "db down")
+}
+
+func startServer(t *testing.T, name string, rcvr interface{}, opts ...server.RegisterOption) string {
+	s := server.NewServer()
+	if err := s.RegisterName(name, rcvr, opts...); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Accept(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_routeByService(t *testing.T) {
+	billing := startServer(t, "billing.v1.User", &User{team: "billing"})
+	shop := startServer(t, "shop.User", &User{team: "shop"})
+	d := NewMultiServerDiscovery([]string{billing, shop})
+	_ = d.UpdateServices(map[string][]string{
+		billing: {"billing.v1.User"},
+		shop:    {"shop.User"},
+	})
+	xc := NewXClient(d, RoundRobinSelect)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		for service, team := range map[string]string{"billing.v1.User": "billing", "shop.User": "shop"} {
+			var reply string
+			if err := xc.Call(context.Background(), service+".Team", 0, &reply); err != nil || reply != team {
+				t.Fatalf("%s: expect %s, got %q, err: %v", service, team, reply, err)
+			}
+		}
+	}
+	var reply string
+	if err := xc.Broadcast(context.Background(), "shop.User.Team", 0, &reply); err != nil || reply != "shop" {
+		t.Fatalf("expect the broadcast to reach the shop servers only, got %q, err: %v", reply, err)
+	}
+	if err := xc.Call(context.Background(), "hr.User.Team", 0, &reply); err == nil {
+		t.Fatal("expect no server for hr.User")
+	}
+	if err := xc.Broadcast(context.Background(), "hr.User.Team", 0, &reply); err == nil {
+		t.Fatal("expect the broadcast to fail without a server for hr.User")
+	}
+}
+
+func TestXClient_routeByVersion(t *testing.T) {
+	v1 := startServer(t, "User", &User{team: "v1"}, server.WithVersion("v1"))
+	v2 := startServer(t, "User", &User{team: "v2"}, server.WithVersion("v2"))
+	d := NewMultiServerDiscovery([]string{v1, v2})
+	_ = d.UpdateServices(map[string][]string{
+		v1: {"User", "User@v1"},
+		v2: {"User", "User@v2"},
+	})
+	xc := NewXClient(d, RoundRobinSelect)
+	defer func() { _ = xc.Close() }()
+
+	for i := 0; i < 4; i++ {
+		for _, version := range []string{"v1", "v2"} {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), server.VersionKey, version)
+			var reply string
+			if err := xc.Call(ctx, "User.Team", 0, &reply); err != nil || reply != version {
+				t.Fatalf("expect %s, got %q, err: %v", version, reply, err)
+			}
+		}
+	}
+}
+
+func TestXClient_methodUnavailable(t *testing.T) {
+	var a, b Flaky
+	xc := NewXClient(NewMultiServerDiscovery([]string{startServer(t, "Flaky", &a), startServer(t, "Flaky", &b)}), RoundRobinSelect)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	err := xc.Call(context.Background(), "Flaky.Down", 0, &reply)
+	var st *status.Status
+	if !errors.As(err, &st) || st.Code != status.Unavailable || st.Message != "db down" {
+		t.Fatalf("expect the error of the method, got %v", err)
+	}
+	// 方法已经执行过, 不能换一个服务端重试
+	if n := atomic.LoadInt32(&a.calls) + atomic.LoadInt32(&b.calls); n != 1 {
+		t.Fatalf("expect the method to run once, ran %d times", n)
+	}
+}
+
+type Blocker struct{ release chan struct{} }
+
+func (b *Blocker) Wait(argv int, reply *int) error {
+	<-b.release
+	return nil
+}
+
+func TestXClient_closeDraining(t *testing.T) {
+	b := &Blocker{release: make(chan struct{})}
+	s := server.NewServer()
+	_ = s.Register(b)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Accept(l) }()
+	defer func() { _ = s.Close() }()
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@" + l.Addr().String()}), RandomSelect)
+	done := make(chan error, 1)
+	go func() { done <- xc.Call(context.Background(), "Blocker.Wait", 0, new(int)) }()
+	for i := 0; len(s.Connections()) == 0 || len(s.Connections()[0].Calls) == 0; i++ {
+		if i == 100 {
+			t.Fatal("expect the call to be in flight")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	// Shutdown 超时, 服务端不再关闭这个连接
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect Shutdown to time out, got %v", err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if err := xc.Call(context.Background(), "Blocker.Wait", 0, new(int)); err == nil {
+		t.Fatal("expect no server to take the call")
+	}
+	close(b.release)
+	if err := <-done; err != nil {
+		t.Fatalf("expect the call in flight to complete, got %v", err)
+	}
+
+	_ = xc.Close()
+	for i := 0; len(s.Connections()) != 0; i++ {
+		if i == 100 {
+			t.Fatalf("expect the draining client to be closed, got %+v", s.Connections())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}